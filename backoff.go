@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Retry delays grow exponentially from Initial by Multiplier up to Max.
+// Each delay is randomised by up to a Jitter fraction either way so that
+// senders don't retry in lockstep.  Retrying stops once MaxElapsed has
+// passed, zero retries forever.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+	MaxElapsed time.Duration
+}
+
+// Delay before retry number n, counting from 0.
+func (b Backoff) Delay(n int) time.Duration {
+
+	d := float64(b.Initial) * math.Pow(b.Multiplier, float64(n))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+
+	if b.Jitter > 0 {
+		d *= 1 + b.Jitter*(2*rand.Float64()-1)
+	}
+
+	return time.Duration(d)
+
+}
+
+// HTTP status error from a sink.
+type StatusError struct {
+	Code   int
+	Status string
+	Body   string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP error, status %s: %s", e.Status, e.Body)
+}
+
+// Error which will happen again however often it's retried.
+type permanentError struct {
+	error
+}
+
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Whether a failed request is worth retrying.  Connection errors,
+// timeouts, throttling and server errors are; other statuses, such as
+// 400 for elements which don't validate, will fail again.
+func Retryable(err error) bool {
+	if _, ok := err.(permanentError); ok {
+		return false
+	}
+	se, ok := err.(*StatusError)
+	if !ok {
+		return true
+	}
+	return se.Code == 408 || se.Code == 429 || se.Code >= 500
+}
+
+// Circuit breaker, opens after Failures consecutive failures and stays
+// open for Cooldown.  After that requests are let through again, one more
+// failure re-opens it and a success closes it.
+type Breaker struct {
+	Failures int
+	Cooldown time.Duration
+
+	// Called with true when the breaker opens, false when it closes.
+	OnChange func(bool)
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	open      bool
+}
+
+// Record a successful request.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openUntil = time.Time{}
+	if b.open {
+		b.open = false
+		if b.OnChange != nil {
+			b.OnChange(false)
+		}
+	}
+}
+
+// Record a failed request.
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.Failures > 0 && b.failures >= b.Failures {
+		b.openUntil = time.Now().Add(b.Cooldown)
+		if !b.open {
+			b.open = true
+			if b.OnChange != nil {
+				b.OnChange(true)
+			}
+		}
+	}
+}
+
+// Time left until requests are allowed, zero if they are now.
+func (b *Breaker) Remaining() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if d := time.Until(b.openUntil); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Wait until requests are allowed.  Returns false if done is closed
+// first.
+func (b *Breaker) Wait(done <-chan struct{}) bool {
+	for {
+		d := b.Remaining()
+		if d == 0 {
+			return true
+		}
+		select {
+		case <-time.After(d):
+		case <-done:
+			return false
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second,
+		Multiplier: 2}
+
+	exp := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, v := range exp {
+		if d := b.Delay(i); d != v*time.Millisecond {
+			t.Errorf("Delay %d: expected %s, got %s", i,
+				v*time.Millisecond, d)
+		}
+	}
+
+	// Jittered delays stay within the fraction.
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := b.Delay(2)
+		if d < 200*time.Millisecond || d > 600*time.Millisecond {
+			t.Errorf("Jittered delay %s out of range", d)
+		}
+	}
+
+}
+
+func TestRetryable(t *testing.T) {
+
+	tests := []struct {
+		err error
+		exp bool
+	}{
+		{errors.New("connection refused"), true},
+		{&StatusError{Code: 500}, true},
+		{&StatusError{Code: 503}, true},
+		{&StatusError{Code: 429}, true},
+		{&StatusError{Code: 408}, true},
+		{&StatusError{Code: 400}, false},
+		{&StatusError{Code: 404}, false},
+	}
+
+	for _, v := range tests {
+		if Retryable(v.err) != v.exp {
+			t.Errorf("%v: expected retryable %v", v.err, v.exp)
+		}
+	}
+
+}
+
+func TestBreaker(t *testing.T) {
+
+	var changes []bool
+	b := &Breaker{Failures: 3, Cooldown: 50 * time.Millisecond,
+		OnChange: func(open bool) { changes = append(changes, open) }}
+
+	b.Failure()
+	b.Failure()
+	if b.Remaining() != 0 {
+		t.Errorf("Open before threshold")
+	}
+
+	b.Failure()
+	if b.Remaining() == 0 {
+		t.Errorf("Not open at threshold")
+	}
+
+	// Waits out the cooldown, or gives up when done.
+	done := make(chan struct{})
+	close(done)
+	if b.Wait(done) {
+		t.Errorf("Wait didn't give up")
+	}
+	start := time.Now()
+	if !b.Wait(nil) || time.Since(start) < 20*time.Millisecond {
+		t.Errorf("Wait didn't wait")
+	}
+
+	// One more failure re-opens it, a success closes it.
+	b.Failure()
+	if b.Remaining() == 0 {
+		t.Errorf("Not re-opened")
+	}
+	b.Success()
+	b.Failure()
+	if b.Remaining() != 0 {
+		t.Errorf("Open after success")
+	}
+
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("Unexpected changes %v", changes)
+	}
+
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+)
+
+// Hostname node, plus the domain it sits in.  The name should be in
+// canonical form.
+func DescribeHostname(name string) []Summarisable {
+
+	elts := []Summarisable{&Node{name, "hostname"}}
+
+	par := ExtractDomain(name)
+	if par != "" {
+		elts = append(elts, &Node{par, "domain"})
+		elts = append(elts, &Edge{name, par, "indomain"})
+	}
+
+	return append(elts, HostnameProperties(name)...)
+
+}
+
+// Properties of an internationalised hostname: the Unicode display form,
+// and a flag for mixed-script names.  Nothing for a plain ASCII name.
+func HostnameProperties(name string) []Summarisable {
+
+	display := DisplayName(name)
+	if display == name {
+		return nil
+	}
+
+	node := Node{name, "hostname"}
+	elts := []Summarisable{&NodeProperty{node, "display", display}}
+
+	if IsHomograph(name) {
+		elts = append(elts, &NodeProperty{node, "homograph", "true"})
+	}
+
+	return elts
+
+}
+
+// Describe a single DNS resource record seen on a network.  Address
+// records link the name to an IP, records which point at another name
+// become a typed edge between hostnames, TXT data is kept as a property
+// on the hostname.
+func DescribeDnsRecord(v DnsRecord, network string) []Summarisable {
+
+	if v.Name == "" {
+		return nil
+	}
+
+	v.Name = CanonicalName(v.Name)
+
+	if v.Address != "" {
+		v.Address = IPVertex(v.Address, network)
+		elts := []Summarisable{
+			&Node{v.Name, "hostname"},
+			&Node{v.Address, "ip"},
+			&Edge{v.Name, v.Address, "dns"},
+		}
+		par := ExtractDomain(v.Name)
+		if par != "" {
+			elts = append(elts, &Node{par, "domain"})
+			elts = append(elts, &Edge{v.Name, par, "indomain"})
+		}
+		return append(elts, HostnameProperties(v.Name)...)
+	}
+
+	if v.Data == "" {
+		return nil
+	}
+
+	switch strings.ToUpper(v.Type) {
+
+	case "PTR":
+
+		// Link the address itself to the name.
+		if addr := ReverseAddress(v.Name); addr != "" {
+			addr = IPVertex(addr, network)
+			target := CanonicalName(v.Data)
+			if target == "" {
+				return nil
+			}
+			elts := []Summarisable{&Node{addr, "ip"}}
+			elts = append(elts, DescribeHostname(target)...)
+			elts = append(elts, &Edge{addr, target, "ptr"})
+			return elts
+		}
+		fallthrough
+
+	case "CNAME", "NS", "MX":
+
+		// MX data is preference followed by exchange.
+		target := v.Data
+		fields := strings.Fields(target)
+		if len(fields) > 0 {
+			target = fields[len(fields)-1]
+		}
+		target = CanonicalName(target)
+
+		// Null MX "0 .", or the root, isn't a host.
+		if target == "" {
+			return nil
+		}
+
+		elts := DescribeHostname(v.Name)
+		elts = append(elts, DescribeHostname(target)...)
+		elts = append(elts,
+			&Edge{v.Name, target, strings.ToLower(v.Type)})
+		return elts
+
+	case "TXT":
+
+		elts := DescribeHostname(v.Name)
+		elts = append(elts,
+			&NodeProperty{Node{v.Name, "hostname"}, "txt", v.Data})
+		return elts
+
+	}
+
+	return nil
+
+}
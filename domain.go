@@ -2,7 +2,10 @@
 package main
 
 import (
+	"fmt"
+	"os"
 	"regexp"
+	"strings"
 )
 
 // Regexps to help navigate base domains.  The purpose of this to pull out
@@ -20,18 +23,81 @@ import (
 // whereas it's probably fine to flag up e.g. co.ck since there's little
 // traffic there.
 //
-// Some domain hierarchies e.g. in-addr.arpa behave differently so it may be
-// necessary to ignore these at some point, but for now, this has not been
-// needed.
+// Reverse DNS names in in-addr.arpa and ip6.arpa aren't hostnames at all,
+// so have no domain.  See reverse.go.
+
+// The Public Suffix List does the same job properly for every registry, so
+// is used by default.  The regexp is kept as a fallback mode.
+
+// Domain extraction modes.
+const (
+	DomainModePsl    = "psl"
+	DomainModeRegexp = "regexp"
+)
 
 var (
 	re = regexp.MustCompile(
 		"([^.]+)" + 
 			"(\\.(gov|judiciary|police|nhs|co|ac|nic|net|mod|parliament|plc|ltd|sch)\\.uk|" +
 			"\\.(fed|isa|nsn|dni|..)\\.us|\\.[^.]+)$")
+
+	// Compiled-in list, including private domains e.g. blogspot.com.
+	suffixList = mustParseSuffixList(publicSuffixData)
+
+	domainMode = DomainModePsl
 )
 
+func mustParseSuffixList(data string) *SuffixList {
+	l, err := ParseSuffixList(strings.NewReader(data), true)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// Select the domain extraction mode.
+func SetDomainMode(mode string) error {
+	switch mode {
+	case DomainModePsl, DomainModeRegexp:
+		domainMode = mode
+		return nil
+	}
+	return fmt.Errorf("unknown domain mode: %s", mode)
+}
+
+// Replace the compiled-in Public Suffix List with one read from a file, so
+// the list can be updated without a rebuild.
+func LoadSuffixList(file string) error {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	l, err := ParseSuffixList(f, true)
+	if err != nil {
+		return err
+	}
+
+	suffixList = l
+	return nil
+
+}
+
+// Registrable domain of a hostname, in canonical form.
 func ExtractDomain(s string) string {
+	s = CanonicalName(s)
+	if IsReverseName(s) {
+		return ""
+	}
+	if domainMode == DomainModeRegexp {
+		return extractDomainRegexp(s)
+	}
+	return suffixList.Domain(s)
+}
+
+func extractDomainRegexp(s string) string {
 	matched := re.FindStringSubmatch(s)
 	if len(matched) < 3 {
 		return ""
@@ -1,7 +1,7 @@
-
 package main
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -19,6 +19,17 @@ func TestDomain(t *testing.T) {
 		{i: "www.gov.uk", o: "www.gov.uk",},
 		{i: "das.house.de", o: "house.de"},
 		{i: "das.house", o: "das.house"},
+		{i: "www.foo.com.au", o: "foo.com.au"},
+		{i: "mail.bar.co.jp", o: "bar.co.jp"},
+		{i: "myblog.blogspot.com", o: "myblog.blogspot.com"},
+		{i: "a.b.example.ck", o: "b.example.ck"},
+		{i: "www.ck", o: "www.ck"},
+		{i: "co.uk", o: ""},
+		{i: "localhost", o: ""},
+		{i: "", o: ""},
+		{i: "WWW.Example.ORG.", o: "example.org"},
+		{i: "www.bücher.de", o: "xn--bcher-kva.de"},
+		{i: "www.example.公司.cn", o: "example.xn--55qx5d.cn"},
 	}
 
 	for _, v := range tests {
@@ -32,3 +43,89 @@ func TestDomain(t *testing.T) {
 
 }
 
+func TestDomainRegexp(t *testing.T) {
+
+	err := SetDomainMode(DomainModeRegexp)
+	if err != nil {
+		t.Fatalf("Couldn't set mode: %s", err.Error())
+	}
+	defer SetDomainMode(DomainModePsl)
+
+	type test struct {
+		i string
+		o string
+	}
+	
+	tests := []test{
+		{i: "www.bunchy.co.uk", o: "bunchy.co.uk"},
+		{i: "www.gov.uk", o: "www.gov.uk",},
+		{i: "foo.bar.ny.us", o: "bar.ny.us"},
+		{i: "www.foo.com.au", o: "com.au"},
+	}
+
+	for _, v := range tests {
+
+		d := ExtractDomain(v. i)
+		if d != v.o {
+			t.Errorf("%s -> %s (%s)", v.i, v.o, d)
+
+		}
+	}
+
+	if SetDomainMode("other") == nil {
+		t.Errorf("Unknown domain mode accepted")
+	}
+
+}
+
+func TestSuffixList(t *testing.T) {
+
+	list := `
+// ===BEGIN ICANN DOMAINS===
+com
+uk
+co.uk
+*.kawasaki.jp
+!city.kawasaki.jp
+// ===END ICANN DOMAINS===
+// ===BEGIN PRIVATE DOMAINS===
+blogspot.com
+// ===END PRIVATE DOMAINS===
+`
+
+	l, err := ParseSuffixList(strings.NewReader(list), false)
+	if err != nil {
+		t.Fatalf("Couldn't parse list: %s", err.Error())
+	}
+
+	type test struct {
+		i string
+		o string
+	}
+
+	tests := []test{
+		{i: "x.blogspot.com", o: "blogspot.com"},
+		{i: "www.Example.CO.UK", o: "Example.CO.UK"},
+		{i: "a.b.kawasaki.jp", o: "a.b.kawasaki.jp"},
+		{i: "www.city.kawasaki.jp", o: "city.kawasaki.jp"},
+		{i: "b.kawasaki.jp", o: ""},
+		{i: "example.com.", o: ""},
+		{i: "a..b", o: ""},
+		{i: "www..example.com", o: ""},
+		{i: ".example.com", o: ""},
+		{i: "", o: ""},
+	}
+
+	for _, v := range tests {
+
+		d := l.Domain(v.i)
+		if d != v.o {
+			t.Errorf("%s -> %s (%s)", v.i, v.o, d)
+		}
+	}
+
+	if s := l.PublicSuffix("www.example.co.uk"); s != "co.uk" {
+		t.Errorf("Public suffix of www.example.co.uk: %s", s)
+	}
+
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	dt "github.com/trustnetworks/analytics-common/datatypes"
+	"strconv"
+	"strings"
+)
+
+// Event is a cyberprobe event as used by the threat graph.  The common
+// datatypes don't model every protocol detail which the graph wants, so
+// the extra fields are decoded alongside from the same JSON object.
+type Event struct {
+	dt.Event
+	Detail EventDetail
+}
+
+// Protocol detail not carried by dt.Event.
+type EventDetail struct {
+	Tls          *Tls          `json:"tls,omitempty"`
+	HttpResponse *HttpResponse `json:"http_response,omitempty"`
+	DnsMessage   *DnsMessage   `json:"dns_message,omitempty"`
+	Volume       *Volume       `json:"volume,omitempty"`
+}
+
+// Traffic volume reported by the probe for the event: payload bytes,
+// packets and, for connection events, duration in seconds.
+type Volume struct {
+	Bytes    int64   `json:"bytes,omitempty"`
+	Packets  int64   `json:"packets,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// TLS handshake information, from tls_client_hello and tls_certificates
+// events.  Certificates are base64-encoded DER, server certificate first.
+type Tls struct {
+	Version      string   `json:"version,omitempty"`
+	ServerName   string   `json:"server_name,omitempty"`
+	Certificates []string `json:"certificates,omitempty"`
+}
+
+// DNS response sections with full resource record data.  Address is set
+// for A and AAAA records, Data holds the record data for other types e.g.
+// the target of a CNAME, "10 mx.example.org" for MX or the TXT string.
+type DnsMessage struct {
+	Answer     []DnsRecord `json:"answer,omitempty"`
+	Authority  []DnsRecord `json:"authority,omitempty"`
+	Additional []DnsRecord `json:"additional,omitempty"`
+	Rcode      DnsRcode    `json:"rcode,omitempty"`
+}
+
+// DNS response code.  The probe may report the numeric code or its name,
+// either way it's held as the name e.g. NXDOMAIN.
+type DnsRcode string
+
+var dnsRcodes = []string{
+	"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED",
+	"YXDOMAIN", "YXRRSET", "NXRRSET", "NOTAUTH", "NOTZONE",
+}
+
+func (r *DnsRcode) UnmarshalJSON(b []byte) error {
+
+	var n int
+	if err := json.Unmarshal(b, &n); err == nil {
+		if n >= 0 && n < len(dnsRcodes) {
+			*r = DnsRcode(dnsRcodes[n])
+		} else {
+			*r = DnsRcode(strconv.Itoa(n))
+		}
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*r = DnsRcode(strings.ToUpper(s))
+	return nil
+
+}
+
+func (r DnsRcode) String() string {
+	return string(r)
+}
+
+type DnsRecord struct {
+	Name    string `json:"name,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Class   string `json:"class,omitempty"`
+	Address string `json:"address,omitempty"`
+	Data    string `json:"data,omitempty"`
+}
+
+// HTTP response status and headers, from http_response events.
+type HttpResponse struct {
+	Code   int               `json:"code,omitempty"`
+	Status string            `json:"status,omitempty"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+// Case-insensitive header lookup, header names aren't normalised by the
+// probe.
+func (r *HttpResponse) GetHeader(name string) string {
+	if v, ok := r.Header[name]; ok {
+		return v
+	}
+	for k, v := range r.Header {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+func (e *Event) UnmarshalJSON(b []byte) error {
+	err := json.Unmarshal(b, &e.Event)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &e.Detail)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Offline conversion of events to graph elements, e.g.
+//
+//   threat-graph export -format turtle -base http://example.org/tg/ events.json
+//
+// Events are read from the files given, or standard input, as a JSON
+// array or a stream of objects.  They're summarised together, so each
+// element appears once, and written to standard output as N-Triples,
+// Turtle or JSON lines of Gaffer elements.  Event description is
+// configured from the environment, as for the loader, and events it
+// can't describe are reported on standard error and skipped.
+func export(args []string) error {
+
+	fl := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fl.String("format", "ntriples",
+		"Output format: ntriples, turtle or json")
+	base := fl.String("base", DefaultRdfBase, "RDF vocabulary base IRI")
+	star := fl.Bool("star", false,
+		"Annotate triples with RDF-star, rather than reification")
+	err := fl.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	err = configureGraph()
+	if err != nil {
+		return err
+	}
+
+	in := []io.Reader{}
+	for _, v := range fl.Args() {
+		f, err := os.Open(v)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = append(in, f)
+	}
+	if len(in) == 0 {
+		in = append(in, os.Stdin)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	err = Export(in, out, *format, *base, *star)
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+
+}
+
+// Read events, a JSON array or a stream of objects, into a summary.
+func readEvents(r io.Reader, sum *Summary) error {
+
+	br := bufio.NewReader(r)
+
+	// Array, or not.
+	array := false
+	for {
+		c, _, err := br.ReadRune()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			continue
+		}
+		array = c == '['
+		br.UnreadRune()
+		break
+	}
+
+	dec := json.NewDecoder(br)
+	if array {
+		dec.Token()
+	}
+	for {
+		if array && !dec.More() {
+			return nil
+		}
+		err := addEvent(dec, sum)
+		if !array && err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+}
+
+// Decode an event and add it to a summary.
+func addEvent(dec *json.Decoder, sum *Summary) error {
+
+	var e Event
+	err := dec.Decode(&e)
+	if err != nil {
+		return err
+	}
+
+	// Skipped, as the loader does, e.g. for a malformed address.
+	elts, tm, err := DescribeThreatElements(e)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: skipping event: %s\n",
+			err.Error())
+		return nil
+	}
+	for _, v := range elts {
+		v.Update(sum, tm)
+	}
+
+	return nil
+
+}
+
+// Summarise events from inputs and write the elements in format.
+func Export(in []io.Reader, out io.Writer, format, base string,
+	star bool) error {
+
+	sum := NewSummary()
+	for _, r := range in {
+		err := readEvents(r, &sum)
+		if err != nil {
+			return err
+		}
+	}
+
+	elements, _ := sum.toGraph(true)
+
+	switch format {
+
+	case "json":
+		b, err := encodeJSONLines(elements)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(b)
+		return err
+
+	case "ntriples", "turtle":
+		elts, err := DecodeElements(elements)
+		if err != nil {
+			return err
+		}
+		w := NewRdfWriter(base, format == "turtle", star)
+		_, err = out.Write(w.Header())
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(w.Encode(elts))
+		return err
+
+	}
+
+	return fmt.Errorf("unknown format: %s", format)
+
+}
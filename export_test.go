@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const exportEvent = `{"time":"2018-05-21T09:19:10.045Z","id":"d346b188-e2c0-4e08-ce64-4528b33d6369","dns_message":{"query":[{"type":"A","class":"IN","name":"www.example.org"}],"answer":[],"type":"query"},"action":"dns_message","dest":["ipv4:8.8.8.8","udp:53","dns"],"network":"test-lan","src":["ipv4:10.0.2.15","udp:45465","dns"],"device":"debug"}`
+
+func TestExportNTriples(t *testing.T) {
+
+	// The same event twice, as a stream.
+	in := strings.NewReader(exportEvent + "\n" + exportEvent + "\n")
+
+	var out bytes.Buffer
+	err := Export([]io.Reader{in}, &out, "ntriples", "http://example.org/tg/",
+		false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nt := out.String()
+
+	for _, v := range []string{
+		`<http://example.org/tg/vertex/10.0.2.15> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://example.org/tg/ip> .`,
+		`<http://example.org/tg/vertex/10.0.2.15> <http://example.org/tg/name> "10.0.2.15" .`,
+		`<http://example.org/tg/vertex/10.0.2.15> <http://example.org/tg/dnsquery> <http://example.org/tg/vertex/www.example.org> .`,
+		` <http://www.w3.org/1999/02/22-rdf-syntax-ns#predicate> <http://example.org/tg/dnsquery> .`,
+		` <http://example.org/tg/count> "2"^^<http://www.w3.org/2001/XMLSchema#long> .`,
+		` <http://example.org/tg/time> "2018-05-21T09:00:00Z"^^<http://www.w3.org/2001/XMLSchema#dateTime> .`,
+		` <http://example.org/tg/timeBucket> "HOUR" .`,
+		` <http://example.org/tg/protocol> "udp" .`,
+	} {
+		if !strings.Contains(nt, v) {
+			t.Errorf("Expected %s", v)
+		}
+	}
+
+	for _, v := range strings.Split(strings.TrimSpace(nt), "\n") {
+		if !strings.HasSuffix(v, " .") || strings.HasPrefix(v, "<<") {
+			t.Errorf("Not an N-Triples statement: %s", v)
+		}
+	}
+
+	// As an array, the same triples apart from blank nodes.
+	in2 := strings.NewReader("[" + exportEvent + ",\n" + exportEvent + "]")
+	var out2 bytes.Buffer
+	err = Export([]io.Reader{in2}, &out2, "ntriples",
+		"http://example.org/tg/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(nt, "\n") != strings.Count(out2.String(), "\n") {
+		t.Errorf("Array and stream differ")
+	}
+
+}
+
+func TestExportBadEvent(t *testing.T) {
+
+	// A malformed address in the middle is skipped.
+	bad := strings.Replace(exportEvent, "ipv4:8.8.8.8", "ipv4:999.1.1.1", 1)
+	in := strings.NewReader(exportEvent + "\n" + bad + "\n" + exportEvent)
+
+	var out bytes.Buffer
+	err := Export([]io.Reader{in}, &out, "ntriples", "http://example.org/tg/",
+		false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nt := out.String()
+
+	if !strings.Contains(nt, ` <http://example.org/tg/count> "2"^^<http://www.w3.org/2001/XMLSchema#long> .`) {
+		t.Errorf("Good events not exported")
+	}
+	if strings.Contains(nt, "999.1.1.1") {
+		t.Errorf("Bad event exported")
+	}
+
+}
+
+func TestExportTurtleStar(t *testing.T) {
+
+	var out bytes.Buffer
+	err := Export([]io.Reader{strings.NewReader(exportEvent)}, &out,
+		"turtle", "http://example.org/tg/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttl := out.String()
+
+	for _, v := range []string{
+		"@prefix tg: <http://example.org/tg/> .\n",
+		"<http://example.org/tg/vertex/10.0.2.15> rdf:type tg:ip .\n",
+		"<< <http://example.org/tg/vertex/10.0.2.15> tg:ipflow <http://example.org/tg/vertex/8.8.8.8> >> tg:observation _:",
+		` tg:count "1"^^xsd:long .`,
+	} {
+		if !strings.Contains(ttl, v) {
+			t.Errorf("Expected %s", v)
+		}
+	}
+	if strings.Contains(ttl, "rdf:Statement") {
+		t.Errorf("Reified with RDF-star")
+	}
+
+}
+
+func TestExportJson(t *testing.T) {
+
+	var out bytes.Buffer
+	err := Export([]io.Reader{strings.NewReader(exportEvent)}, &out,
+		"json", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 4 entities, 3 edges.
+	if n := strings.Count(out.String(), "\n"); n != 7 {
+		t.Errorf("Expected 7 elements, got %d", n)
+	}
+
+	if Export(nil, &out, "xml", "", false) == nil {
+		t.Errorf("Unknown format accepted")
+	}
+
+}
+
+func TestRdfEscaping(t *testing.T) {
+
+	w := NewRdfWriter("", false, false)
+	nt := string(w.Encode([]GafferElement{{
+		Class:  "uk.gov.gchq.gaffer.data.element.Entity",
+		Group:  "useragent",
+		Vertex: "Mozilla \"5.0\" <x>\n",
+	}}))
+
+	for _, v := range []string{
+		`<http://trustnetworks.com/threat-graph/vertex/Mozilla%20%225.0%22%20%3Cx%3E%0A>`,
+		`"Mozilla \"5.0\" <x>\n"`,
+	} {
+		if !strings.Contains(nt, v) {
+			t.Errorf("Expected %s in %s", v, nt)
+		}
+	}
+
+}
+
+func TestRdfSink(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink, err := OpenSink("turtle://" + dir + "/graph?maxBytes=100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSummary()
+	(&Node{"10.0.2.15", "ip"}).Update(&s,
+		time.Date(2018, 5, 21, 9, 19, 10, 0, time.UTC))
+	elts, _ := s.toGraph(true)
+	for i := 0; i < 3; i++ {
+		if err := sink.Write(elts); err != nil {
+			t.Errorf("Write: %s", err.Error())
+		}
+	}
+	sink.Close()
+
+	// Every file has the prefixes.
+	names, _ := filepath.Glob(dir + "/graph-*.ttl")
+	if len(names) != 3 {
+		t.Errorf("Expected 3 files, got %d", len(names))
+	}
+	for _, v := range names {
+		b, _ := ioutil.ReadFile(v)
+		if !strings.HasPrefix(string(b), "@prefix tg: ") ||
+			!strings.Contains(string(b), "rdf:type tg:ip .") {
+			t.Errorf("Unexpected Turtle %s", b)
+		}
+	}
+
+}
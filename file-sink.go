@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// JSON-lines file sink, one Gaffer element per line exactly as it would
+// go in an AddElements input list.  The URL path is a file name prefix,
+// query parameters set rotation and compression, e.g.
+//
+//   file:///var/lib/threat-graph/elements?maxBytes=100000000&interval=1h&gzip=true
+//
+// writes elements-20180521T091910Z-000001.jsonl.gz, and so on.  Files
+// rotate once maxBytes of elements are written or after interval, zero
+// means never.
+//
+// ntriples:// and turtle:// URLs write RDF files the same way, with base
+// and star parameters for the RdfWriter, e.g.
+//
+//   turtle:///var/lib/threat-graph/graph?base=http://example.org/tg/&star=true
+type FileSink struct {
+	prefix   string
+	maxBytes int64
+	interval time.Duration
+	gzip     bool
+
+	// File extension, start of every file and element encoding.
+	ext    string
+	header []byte
+	encode func([]interface{}) ([]byte, error)
+
+	mu     sync.Mutex
+	file   *os.File
+	gz     *gzip.Writer
+	opened time.Time
+	size   int64
+	seq    int
+}
+
+// One JSON element per line.
+func encodeJSONLines(elements []interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, v := range elements {
+		j, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't marshal json: %s",
+				err.Error())
+		}
+		buf.Write(j)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func init() {
+
+	RegisterSink("file", func(u *url.URL) (GraphSink, error) {
+		fs, err := openFileSink(u)
+		if err != nil {
+			return nil, err
+		}
+		fs.ext = ".jsonl"
+		fs.encode = encodeJSONLines
+		return fs, nil
+	})
+
+	rdf := func(u *url.URL) (GraphSink, error) {
+		fs, err := openFileSink(u)
+		if err != nil {
+			return nil, err
+		}
+		q := u.Query()
+		w := NewRdfWriter(q.Get("base"), u.Scheme == "turtle",
+			q.Get("star") == "true")
+		fs.ext = ".nt"
+		if w.Turtle {
+			fs.ext = ".ttl"
+		}
+		fs.header = w.Header()
+		fs.encode = func(elements []interface{}) ([]byte, error) {
+			elts, err := DecodeElements(elements)
+			if err != nil {
+				return nil, err
+			}
+			return w.Encode(elts), nil
+		}
+		return fs, nil
+	}
+	RegisterSink("ntriples", rdf)
+	RegisterSink("turtle", rdf)
+
+}
+
+// File sink for a URL, without its encoding.
+func openFileSink(u *url.URL) (*FileSink, error) {
+
+	q := u.Query()
+	fs := &FileSink{prefix: u.Path}
+	if u.Path == "" {
+		// Relative path, file:elements
+		fs.prefix = u.Opaque
+	}
+	if fs.prefix == "" {
+		return nil, fmt.Errorf("file sink needs a path")
+	}
+
+	var err error
+	if v := q.Get("maxBytes"); v != "" {
+		fs.maxBytes, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("maxBytes: %s", err.Error())
+		}
+	}
+	if v := q.Get("interval"); v != "" {
+		fs.interval, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("interval: %s", err.Error())
+		}
+	}
+	fs.gzip = q.Get("gzip") == "true"
+
+	err = os.MkdirAll(filepath.Dir(fs.prefix), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+
+}
+
+// Open the next file.
+func (fs *FileSink) open() error {
+
+	fs.seq++
+	fs.opened = time.Now()
+	fs.size = 0
+
+	name := fmt.Sprintf("%s-%s-%06d%s", fs.prefix,
+		fs.opened.UTC().Format("20060102T150405Z"), fs.seq, fs.ext)
+	if fs.gzip {
+		name += ".gz"
+	}
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	fs.file = f
+	if fs.gzip {
+		fs.gz = gzip.NewWriter(f)
+	}
+
+	if len(fs.header) > 0 {
+		return fs.write(fs.header)
+	}
+
+	return nil
+
+}
+
+// Close the current file, if any.
+func (fs *FileSink) close() error {
+
+	if fs.file == nil {
+		return nil
+	}
+
+	var err error
+	if fs.gz != nil {
+		err = fs.gz.Close()
+		fs.gz = nil
+	}
+	if cerr := fs.file.Close(); err == nil {
+		err = cerr
+	}
+	fs.file = nil
+
+	return err
+
+}
+
+// Time to start a new file.
+func (fs *FileSink) due() bool {
+	if fs.maxBytes > 0 && fs.size >= fs.maxBytes {
+		return true
+	}
+	if fs.interval > 0 && time.Since(fs.opened) >= fs.interval {
+		return true
+	}
+	return false
+}
+
+// Append elements, a batch is never split across files.
+func (fs *FileSink) Write(elements []interface{}) error {
+
+	buf, err := fs.encode(elements)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.file != nil && fs.due() {
+		err := fs.close()
+		if err != nil {
+			return err
+		}
+	}
+	if fs.file == nil {
+		err := fs.open()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = fs.write(buf)
+	if err != nil {
+		return err
+	}
+	fs.size += int64(len(buf))
+
+	return nil
+
+}
+
+// Write to the current file.
+func (fs *FileSink) write(b []byte) error {
+
+	var w io.Writer = fs.file
+	if fs.gz != nil {
+		w = fs.gz
+	}
+	_, err := w.Write(b)
+	if err != nil {
+		return err
+	}
+	if fs.gz != nil {
+		// Readable up to here if we stop.
+		return fs.gz.Flush()
+	}
+	return nil
+
+}
+
+func (fs *FileSink) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.close()
+}
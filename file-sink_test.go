@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Lines from each file written by a file sink.
+func readLines(t *testing.T, pattern string, gz bool) [][]string {
+
+	names, _ := filepath.Glob(pattern)
+	sort.Strings(names)
+
+	files := [][]string{}
+	for _, v := range names {
+		f, err := os.Open(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = f
+		if gz {
+			r, err = gzip.NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		lines := []string{}
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			lines = append(lines, sc.Text())
+		}
+		f.Close()
+		files = append(files, lines)
+	}
+
+	return files
+
+}
+
+func TestFileSink(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewSummary()
+	(&Edge{"10.0.2.15", "8.8.8.8", "ipflow"}).Update(&s, time.Date(2018, 5, 21, 9, 19, 10, 0, time.UTC))
+	elts, _ := s.toGraph(true)
+
+	for _, gz := range []bool{false, true} {
+
+		url := "file://" + dir + "/plain/elements?maxBytes=1000"
+		pattern := dir + "/plain/elements-*.jsonl"
+		if gz {
+			url = "file://" + dir + "/gz/elements?maxBytes=1000&gzip=true"
+			pattern = dir + "/gz/elements-*.jsonl.gz"
+		}
+
+		sink, err := OpenSink(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			err = sink.Write(elts)
+			if err != nil {
+				t.Errorf("Write: %s", err.Error())
+			}
+		}
+		sink.Close()
+
+		// Each line is an input element, and files rotate.
+		files := readLines(t, pattern, gz)
+		if len(files) < 2 {
+			t.Errorf("Expected rotation, got %d files", len(files))
+		}
+		exp, _ := json.Marshal(elts[0])
+		lines := 0
+		for _, f := range files {
+			for _, v := range f {
+				if v != string(exp) {
+					t.Errorf("Unexpected line %s", v)
+				}
+				lines++
+			}
+		}
+		if lines != 10 {
+			t.Errorf("Expected 10 lines, got %d", lines)
+		}
+
+	}
+
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	dt "github.com/trustnetworks/analytics-common/datatypes"
+)
+
+const (
+	MaxIdleConns        = 50 // Maximum number of idle connection to leave in pool
+	MaxIdleConnsPerHost = 5  // Maximum number of idle connection to leave in pool
+	CnxTimeout          = 5  // How long before a connection timesout
+	RefreshSecs         = 10 // How many requests to make before we refresh the cnx pool
+)
+
+// Gaffer REST API sink, elements are PUT as AddElements operations.
+type GafferSink struct {
+	url    string
+	client *http.Client
+	tp     *http.Transport
+	done   chan struct{}
+}
+
+func init() {
+	open := func(u *url.URL) (GraphSink, error) {
+		v := *u
+		switch v.Scheme {
+		case "gaffer":
+			v.Scheme = "http"
+		case "gaffers":
+			v.Scheme = "https"
+		}
+		return NewGafferSink(v.String()), nil
+	}
+	RegisterSink("gaffer", open)
+	RegisterSink("gaffers", open)
+	RegisterSink("http", open)
+	RegisterSink("https", open)
+}
+
+// Sink for the Gaffer REST API at url e.g.
+// http://gaffer-threat:8080/rest/v1.
+func NewGafferSink(url string) *GafferSink {
+
+	// Create an HTTP transport and client for Gaffer.
+	tp := &http.Transport{
+		MaxIdleConnsPerHost: MaxIdleConnsPerHost,
+		MaxIdleConns:        MaxIdleConns,
+	}
+
+	g := &GafferSink{
+		url: strings.TrimSuffix(url, "/"),
+		client: &http.Client{
+			Transport: tp,
+			Timeout:   CnxTimeout * time.Second,
+		},
+		tp:   tp,
+		done: make(chan struct{}),
+	}
+
+	// Refresh idle connections every Xs
+	go func() {
+		tck := time.NewTicker(RefreshSecs * time.Second)
+		defer tck.Stop()
+		for {
+			select {
+			case <-tck.C:
+				tp.CloseIdleConnections()
+			case <-g.done:
+				return
+			}
+		}
+	}()
+
+	return g
+
+}
+
+// PUT elements to Gaffer, once.
+func (g *GafferSink) Write(elements []interface{}) error {
+
+	body := &dt.Bundle{
+		"class":               "uk.gov.gchq.gaffer.operation.impl.add.AddElements",
+		"validate":            true,
+		"skipInvalidElements": false,
+		"input":               elements,
+	}
+
+	j, err := json.Marshal(body)
+	if err != nil {
+		return Permanent(fmt.Errorf("Couldn't marshal json: %s",
+			err.Error()))
+	}
+
+	req, _ := http.NewRequest("PUT",
+		g.url+"/graph/doOperation/add/elements",
+		strings.NewReader(string(j)))
+	req.ContentLength = int64(len(j))
+	req.Header.Set("Content-Type", "application/json")
+
+	response, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Couldn't make HTTP request: %s",
+			err.Error())
+	}
+
+	rtn, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+
+	if response.StatusCode != 204 {
+		return &StatusError{response.StatusCode, response.Status,
+			string(rtn)}
+	}
+
+	return nil
+
+}
+
+func (g *GafferSink) Close() error {
+	close(g.done)
+	g.tp.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,43 @@
+// +build ignore
+
+// Generates public-suffix-data.go from public_suffix_list.dat so that the
+// list is compiled in.  To update, fetch a new list and run go generate:
+//
+//   curl -o public_suffix_list.dat \
+//       https://publicsuffix.org/list/public_suffix_list.dat
+//   go generate
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+func main() {
+
+	data, err := ioutil.ReadFile("public_suffix_list.dat")
+	if err != nil {
+		fmt.Printf("Couldn't read list: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if strings.Contains(string(data), "`") {
+		fmt.Println("List contains a backquote, can't generate")
+		os.Exit(1)
+	}
+
+	out := "// Code generated by gen-public-suffix.go; DO NOT EDIT.\n\n" +
+		"package main\n\n" +
+		"// Contents of public_suffix_list.dat.\n" +
+		"const publicSuffixData = `" + string(data) + "`\n"
+
+	err = ioutil.WriteFile("public-suffix-data.go", []byte(out), 0644)
+	if err != nil {
+		fmt.Printf("Couldn't write data: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+}
@@ -3,25 +3,160 @@ package main
 import (
         dt "github.com/trustnetworks/analytics-common/datatypes"
         "time"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
 	"strings"
 	"regexp"
 )
 
 var (
 	ipAddrRegex = regexp.MustCompile("^[0-9]+\\.[0-9]+\\.[0-9]+\\.[0-9]+$")
+
+	// Optional parts of the graph, set at initialisation.
+	Options DescribeOptions
 )
 
+// Optional graph elements.
+type DescribeOptions struct {
+
+	// Add service nodes e.g. tcp/443, linked from the IPs exposing them.
+	Services bool
+
+	// Qualify private address vertices by network, see IPVertex.
+	ScopePrivate bool
+
+	// Add firstSeen/lastSeen properties, milliseconds since the epoch.
+	SeenProperties bool
+
+	// Edge groups for which nodes carry distinct-neighbour sketches.
+	SketchGroups map[string]bool
+
+	// Output elements in a fixed order, see ToGraph.
+	Sorted bool
+}
+
 type State struct {
 	Count int
-	Times map[time.Time]bool
+
+	// Time buckets seen, and the earliest and latest times.
+	Times     TimeBuckets
+	FirstSeen time.Time
+	LastSeen  time.Time
+
+	// Set-valued properties, created on first use.
+	Props map[string]map[string]bool
+
+	// Traffic volume, edges only, created on first use.
+	Volume *VolumeState
+
+	// Distinct-neighbour sketches, nodes only, created on first use.
+	Sketches map[string]*HyperLogLog
 }
 
-func NewState() *State {
+// Sum, minimum and maximum of a volume measure over observations.
+type Measure struct {
+	Sum, Min, Max int64
+}
+
+func (this *Measure) Add(v int64, first bool) {
+	this.Sum += v
+	if first || v < this.Min {
+		this.Min = v
+	}
+	if first || v > this.Max {
+		this.Max = v
+	}
+}
+
+// Volume of an edge.  Duration is in milliseconds.
+type VolumeState struct {
+	Bytes    Measure
+	Packets  Measure
+	Duration Measure
+}
+
+// State for an element of a group, times are bucketed as configured for
+// the group.
+func NewState(group string) *State {
 	return &State{
-		Count: 0, Times: map[time.Time]bool{},
+		Count: 0, Times: NewTimeBuckets(BucketFor(group)),
 	}
 }
 
+// Approximate serialised sizes of element parts, for keeping AddElements
+// bodies bounded.
+const (
+	entitySize    = 200 // Class, group, count and time set framing
+	edgeSize      = 250
+	timestampSize = 12
+	propertySize  = 40 // Set framing
+	volumeSize    = 250
+	sketchSize    = 1200 // Base64 registers at SketchPrecision
+)
+
+// Add a property value, returns the estimated serialised size added.
+func (this *State) AddProp(key, value string) int {
+	size := 0
+	if this.Props == nil {
+		this.Props = map[string]map[string]bool{}
+	}
+	if _, ok := this.Props[key]; !ok {
+		this.Props[key] = map[string]bool{}
+		size += propertySize + len(key)
+	}
+	if !this.Props[key][value] {
+		this.Props[key][value] = true
+		size += len(value) + 3
+	}
+	return size
+}
+
+// Add a volume observation, returns the estimated serialised size added.
+func (this *State) AddVolume(v Volume) int {
+	size := 0
+	first := this.Volume == nil
+	if first {
+		this.Volume = &VolumeState{}
+		size = volumeSize
+	}
+	this.Volume.Bytes.Add(v.Bytes, first)
+	this.Volume.Packets.Add(v.Packets, first)
+	this.Volume.Duration.Add(int64(v.Duration*1000), first)
+	return size
+}
+
+// Add a neighbour to a sketch, returns the estimated serialised size
+// added.
+func (this *State) AddNeighbour(key, neighbour string) int {
+	size := 0
+	if this.Sketches == nil {
+		this.Sketches = map[string]*HyperLogLog{}
+	}
+	if _, ok := this.Sketches[key]; !ok {
+		this.Sketches[key] = NewHyperLogLog(SketchPrecision)
+		size = sketchSize
+	}
+	this.Sketches[key].Offer(neighbour)
+	return size
+}
+
+// Add an observation, returns the estimated serialised size added.
+func (this *State) Observe(tm time.Time) int {
+	this.Count += 1
+	if this.FirstSeen.IsZero() || tm.Before(this.FirstSeen) {
+		this.FirstSeen = tm
+	}
+	if tm.After(this.LastSeen) {
+		this.LastSeen = tm
+	}
+	if !this.Times.Add(tm) {
+		return 0
+	}
+	return timestampSize
+}
+
 type Node struct {
 	Name string
 	Group string
@@ -33,9 +168,44 @@ type Edge struct {
 	Group string
 }
 
+// A property value recorded against a node.  Values accumulate as a set,
+// and don't count as an observation of the node.
+type NodeProperty struct {
+	Node
+	Key   string
+	Value string
+}
+
+// A property value recorded against an edge, as for NodeProperty.
+type EdgeProperty struct {
+	Edge
+	Key   string
+	Value string
+}
+
+// Traffic volume recorded against an edge.  Doesn't count as an
+// observation of the edge.
+type EdgeVolume struct {
+	Edge
+	Volume Volume
+}
+
+// A neighbour of a node over edges of some group, counted in a distinct
+// sketch.  Key is the edge group plus Sources or Destinations e.g. a
+// server's webrequestSources counts distinct clients.  Doesn't count as
+// an observation of the node.
+type NodeNeighbour struct {
+	Node
+	Key       string
+	Neighbour string
+}
+
 type Summary struct {
 	Nodes map[Node]*State
 	Edges map[Edge]*State
+
+	// Estimated size of the Gaffer elements in bytes.
+	Size int
 }
 
 func NewSummary() Summary {
@@ -45,59 +215,207 @@ func NewSummary() Summary {
 	}
 }
 
+// Nodes in order of group then vertex.
+func (this *Summary) sortedNodes() []Node {
+	keys := make([]Node, 0, len(this.Nodes))
+	for k, _ := range this.Nodes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Group != keys[j].Group {
+			return keys[i].Group < keys[j].Group
+		}
+		return keys[i].Name < keys[j].Name
+	})
+	return keys
+}
+
+// Edges in order of group, source then destination.
+func (this *Summary) sortedEdges() []Edge {
+	keys := make([]Edge, 0, len(this.Edges))
+	for k, _ := range this.Edges {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Destination < b.Destination
+	})
+	return keys
+}
+
+// Convert to Gaffer elements.  Map order is random, so with
+// Options.Sorted the entities come first, then the edges, each sorted by
+// group and vertex, so the same summary always gives the same payload.
 func (this *Summary) ToGraph() ([]interface{}, error) {
+	return this.toGraph(Options.Sorted)
+}
+
+func (this *Summary) toGraph(sorted bool) ([]interface{}, error) {
         elements := []interface{}{}
 
-	for k, v := range this.Nodes {
-		tss := dt.NewTimestampSet("HOUR")
-		for tm, _ := range v.Times {
-			ts := uint64(tm.Unix())
-			tss.Add(ts)
+	var nodes []Node
+	var edges []Edge
+	if sorted {
+		nodes = this.sortedNodes()
+		edges = this.sortedEdges()
+	} else {
+		for k, _ := range this.Nodes {
+			nodes = append(nodes, k)
+		}
+		for k, _ := range this.Edges {
+			edges = append(edges, k)
+		}
+	}
+
+	for _, k := range nodes {
+		v := this.Nodes[k]
+		tss := dt.NewTimestampSet(v.Times.Bucket())
+		for _, ts := range v.Times.Timestamps() {
+			tss.Add(uint64(ts))
+		}
+		ent := dt.NewEntity(k.Name, k.Group).
+			SetProperty("count", v.Count).
+			SetProperty("time", tss)
+		for p, vals := range v.Props {
+			ent = ent.SetProperty(p, NewStringSet(vals))
 		}
-                elements = append(elements,
-                        dt.NewEntity(k.Name, k.Group).
-				SetProperty("count", v.Count).
-				SetProperty("time", tss))
+		if Options.SeenProperties {
+			ent = ent.SetProperty("firstSeen", millis(v.FirstSeen)).
+				SetProperty("lastSeen", millis(v.LastSeen))
+		}
+		for p, sk := range v.Sketches {
+			ent = ent.SetProperty(p, sk.Gaffer())
+		}
+                elements = append(elements, ent)
 	}
 
-	for k, v := range this.Edges {
-		tss := dt.NewTimestampSet("HOUR")
-		for tm, _ := range v.Times {
-			ts := uint64(tm.Unix())
-			tss.Add(ts)
+	for _, k := range edges {
+		v := this.Edges[k]
+		tss := dt.NewTimestampSet(v.Times.Bucket())
+		for _, ts := range v.Times.Timestamps() {
+			tss.Add(uint64(ts))
+		}
+		edge := dt.NewEdge(k.Source, k.Destination, k.Group).
+			SetProperty("count", v.Count).
+			SetProperty("time", tss)
+		for p, vals := range v.Props {
+			edge = edge.SetProperty(p, NewStringSet(vals))
 		}
-                elements = append(elements,
-                        dt.NewEdge(k.Source, k.Destination, k.Group).
-				SetProperty("count", v.Count).
-				SetProperty("time", tss))
+		if Options.SeenProperties {
+			edge = edge.SetProperty("firstSeen", millis(v.FirstSeen)).
+				SetProperty("lastSeen", millis(v.LastSeen))
+		}
+		if vol := v.Volume; vol != nil {
+			edge = edge.SetProperty("bytes", vol.Bytes.Sum).
+				SetProperty("minBytes", vol.Bytes.Min).
+				SetProperty("maxBytes", vol.Bytes.Max).
+				SetProperty("packets", vol.Packets.Sum).
+				SetProperty("minPackets", vol.Packets.Min).
+				SetProperty("maxPackets", vol.Packets.Max).
+				SetProperty("duration", vol.Duration.Sum).
+				SetProperty("minDuration", vol.Duration.Min).
+				SetProperty("maxDuration", vol.Duration.Max)
+		}
+                elements = append(elements, edge)
 	}
 
 	return elements, nil
 	
 }
 
+// Java-style timestamp, for Gaffer long properties.
+func millis(tm time.Time) int64 {
+	return tm.UnixNano() / int64(time.Millisecond)
+}
+
+// Gaffer JSON form of a set of strings.
+func NewStringSet(vals map[string]bool) map[string]interface{} {
+	set := []string{}
+	for v, _ := range vals {
+		set = append(set, v)
+	}
+	sort.Strings(set)
+	return map[string]interface{}{"java.util.TreeSet": set}
+}
+
+// Summarisable elements update a Summary, and hash on the Node or Edge
+// they update so that all updates to a key go to the same place.
 type Summarisable interface {
 	Update(*Summary, time.Time)
+	Hash() uint64
 }
 
-func (this *Node) Update(s *Summary, tm time.Time) {
-	if _, ok := s.Nodes[*this]; !ok {
-		s.Nodes[*this] = NewState()
+func (this *Node) Hash() uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(this.Group))
+	h.Write([]byte{0})
+	h.Write([]byte(this.Name))
+	return h.Sum64()
+}
+
+func (this *Edge) Hash() uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(this.Group))
+	h.Write([]byte{0})
+	h.Write([]byte(this.Source))
+	h.Write([]byte{0})
+	h.Write([]byte(this.Destination))
+	return h.Sum64()
+}
+
+func (this *Summary) nodeState(n Node) *State {
+	st, ok := this.Nodes[n]
+	if !ok {
+		st = NewState(n.Group)
+		this.Nodes[n] = st
+		this.Size += entitySize + len(n.Name) + len(n.Group)
 	}
-	s.Nodes[*this].Count += 1
-	s.Nodes[*this].Times[tm] = true
+	return st
 }
 
-func (this *Edge) Update(s *Summary, tm time.Time) {
-	if _, ok := s.Edges[*this]; !ok {
-		s.Edges[*this] = NewState()
+func (this *Summary) edgeState(e Edge) *State {
+	st, ok := this.Edges[e]
+	if !ok {
+		st = NewState(e.Group)
+		this.Edges[e] = st
+		this.Size += edgeSize + len(e.Source) + len(e.Destination) +
+			len(e.Group)
 	}
-	s.Edges[*this].Count += 1
-	s.Edges[*this].Times[tm] = true
+	return st
+}
+
+func (this *Node) Update(s *Summary, tm time.Time) {
+	s.Size += s.nodeState(*this).Observe(tm)
+}
+
+func (this *NodeProperty) Update(s *Summary, tm time.Time) {
+	s.Size += s.nodeState(this.Node).AddProp(this.Key, this.Value)
+}
+
+func (this *EdgeProperty) Update(s *Summary, tm time.Time) {
+	s.Size += s.edgeState(this.Edge).AddProp(this.Key, this.Value)
+}
+
+func (this *NodeNeighbour) Update(s *Summary, tm time.Time) {
+	s.Size += s.nodeState(this.Node).AddNeighbour(this.Key, this.Neighbour)
+}
+
+func (this *EdgeVolume) Update(s *Summary, tm time.Time) {
+	s.Size += s.edgeState(this.Edge).AddVolume(this.Volume)
+}
+
+func (this *Edge) Update(s *Summary, tm time.Time) {
+	s.Size += s.edgeState(*this).Observe(tm)
 }
 
 // Handle a single JSON object.
-func DescribeThreatElements(e dt.Event) ([]Summarisable, time.Time, error) {
+func DescribeThreatElements(e Event) ([]Summarisable, time.Time, error) {
 
         device  := e.Device
         network := e.Network
@@ -108,25 +426,39 @@ func DescribeThreatElements(e dt.Event) ([]Summarisable, time.Time, error) {
 	tm = tm.Round(time.Second)
 
 	_ = device
-	_ = network
 	_ = tm
 
-	sip, sport, sproto := ParseAddress(e.Src)
-	dip, dport, dproto := ParseAddress(e.Dest)
+	src, err := ParseAddress(e.Src)
+	if err != nil {
+		return nil, tm, err
+	}
+	dst, err := ParseAddress(e.Dest)
+	if err != nil {
+		return nil, tm, err
+	}
 
-	if sip == "" || dip == "" { return nil, tm, nil }
+	if src.IP == nil || dst.IP == nil { return nil, tm, nil }
 
-	_ = sport
-	_ = sproto
-	_ = dport
-	_ = dproto
+	sip := IPVertex(src.Addr(), network)
+	dip := IPVertex(dst.Addr(), network)
 
 	elts := []Summarisable{}
 
 	// Add ipflow edge between two IPs.
 	elts = append(elts, &Node{sip, "ip"})
 	elts = append(elts, &Node{dip, "ip"})
-	elts = append(elts, &Edge{sip, dip, "ipflow"})
+	flow := Edge{sip, dip, "ipflow"}
+	elts = append(elts, &flow)
+	if src.Proto != "" {
+		elts = append(elts, &EdgeProperty{flow, "protocol", src.Proto})
+	}
+	if e.Detail.Volume != nil {
+		elts = append(elts, &EdgeVolume{flow, *e.Detail.Volume})
+	}
+
+	if Options.Services {
+		elts = append(elts, DescribeService(src, dst, network)...)
+	}
 
 	if e.Origin != "" {
 		elts = append(elts, &Node{e.Device, "device"})
@@ -137,53 +469,83 @@ func DescribeThreatElements(e dt.Event) ([]Summarisable, time.Time, error) {
 		}
 	}
 
+	// Device and its address are on the network.
+	if network != "" && e.Origin != "" {
+		devip := sip
+		if e.Origin == "network" {
+			devip = dip
+		}
+		elts = append(elts, &Node{network, "network"})
+		elts = append(elts, &Edge{e.Device, network, "innetwork"})
+		elts = append(elts, &Edge{devip, network, "innetwork"})
+	}
+
 	if e.Action == "dns_message" && e.DnsMessage != nil &&
 		e.DnsMessage.Type == "query" && e.DnsMessage.Query != nil {
 		for _, v := range e.DnsMessage.Query {
-			if v.Name != "" {
-				elts = append(elts,
-					&Node{v.Name, "hostname"})
-				elts = append(elts,
-					&Edge{sip, v.Name, "dnsquery"})
+			if v.Name == "" {
+				continue
+			}
 
-				par := ExtractDomain(v.Name)
-				if par != "" {
-					elts = append(elts,
-						&Node{par, "domain"})
-					elts = append(elts,
-						&Edge{v.Name, par, "indomain"})
+			name := CanonicalName(v.Name)
 
-				}
+			// Reverse lookups are a question about an address.
+			if addr := ReverseAddress(name); addr != "" {
+				addr = IPVertex(addr, network)
+				elts = append(elts, &Node{addr, "ip"})
+				elts = append(elts,
+					&Edge{sip, addr, "reverselookup"})
+				continue
+			}
 
+			elts = append(elts, &Node{name, "hostname"})
+			elts = append(elts, &Edge{sip, name, "dnsquery"})
+
+			par := ExtractDomain(name)
+			if par != "" {
+				elts = append(elts, &Node{par, "domain"})
+				elts = append(elts, &Edge{name, par, "indomain"})
 			}
+
+			elts = append(elts, HostnameProperties(name)...)
+
 		}
 	}
 
-	// 
+	// Answers, plus any delegation or glue records.
 	if e.Action == "dns_message" && e.DnsMessage != nil &&
-		e.DnsMessage.Type == "response" && e.DnsMessage.Answer != nil {
-		for _, v := range e.DnsMessage.Answer {
-
-			if v.Name != "" && v.Address != "" {
-
-				elts = append(elts,
-					&Node{v.Name, "hostname"})
+		e.DnsMessage.Type == "response" && e.Detail.DnsMessage != nil {
+		dns := e.Detail.DnsMessage
+		for _, sect := range [][]DnsRecord{dns.Answer, dns.Authority,
+			dns.Additional} {
+			for _, v := range sect {
 				elts = append(elts,
-					&Node{v.Address, "ip"})
-				elts = append(elts,
-					&Edge{v.Name, v.Address,
-						"dns"})
-				
-				par := ExtractDomain(v.Name)
-				if par != "" {
-					elts = append(elts,
-						&Node{par, "domain"})
+					DescribeDnsRecord(v, network)...)
+			}
+		}
+
+		// Failed lookups, the querier is the response destination.
+		rcode := dns.Rcode.String()
+		if rcode != "" && rcode != "NOERROR" {
+			for _, v := range e.DnsMessage.Query {
+				if v.Name == "" {
+					continue
+				}
+				name := CanonicalName(v.Name)
+				if addr := ReverseAddress(name); addr != "" {
+					name = IPVertex(addr, network)
+					elts = append(elts, &Node{name, "ip"})
+				} else {
 					elts = append(elts,
-						&Edge{v.Name, par, "indomain"})
+						DescribeHostname(name)...)
 				}
-					
+				fail := Edge{dip, name, "dnsfailure"}
+				elts = append(elts, &fail)
+				elts = append(elts,
+					&EdgeProperty{fail, "rcode", rcode})
 			}
 		}
+
 	}
 
 	if e.Action == "http_request" && e.HttpRequest != nil {
@@ -201,6 +563,8 @@ func DescribeThreatElements(e dt.Event) ([]Summarisable, time.Time, error) {
 
 		if host != "" {
 
+			host = CanonicalHost(host)
+
 			// FIXME: Look at this.
 			elts = append(elts,
 				&Node{host, "server"})
@@ -209,13 +573,9 @@ func DescribeThreatElements(e dt.Event) ([]Summarisable, time.Time, error) {
 			elts = append(elts,
 				&Edge{dip, host, "serves"})
 
-			hostpart := host
-			ix := strings.IndexAny(hostpart, ":")
-			if ix >= 0 {
-				hostpart = hostpart[:ix]
-			}
+			hostpart, _ := splitHost(host)
 
-			if !ipAddrRegex.MatchString(hostpart) {
+			if net.ParseIP(hostpart) == nil {
 
 				domain := ExtractDomain(hostpart)
 				if domain != "" {
@@ -230,58 +590,171 @@ func DescribeThreatElements(e dt.Event) ([]Summarisable, time.Time, error) {
 		}
 	}
 
+	// Responses come from the server, so the server is the source.
+	if e.Action == "http_response" && e.Detail.HttpResponse != nil {
+
+		resp := e.Detail.HttpResponse
+
+		sw := resp.GetHeader("Server")
+		if sw != "" {
+			elts = append(elts, &Node{sw, "serverSoftware"})
+			elts = append(elts, &Edge{sip, sw, "runs"})
+		}
+
+		class := StatusClass(resp.Code)
+		if class != "" {
+			elts = append(elts, &Node{class, "httpstatus"})
+			elts = append(elts, &Edge{sip, class, "returned"})
+		}
+
+		ct := ContentType(resp.GetHeader("Content-Type"))
+		if ct != "" {
+			elts = append(elts, &Node{ct, "contenttype"})
+			elts = append(elts, &Edge{sip, ct, "served"})
+		}
+
+	}
+
+	if e.Action == "tls_client_hello" && e.Detail.Tls != nil &&
+		e.Detail.Tls.ServerName != "" {
+
+		sni := CanonicalName(e.Detail.Tls.ServerName)
+
+		elts = append(elts, &Node{sni, "hostname"})
+		elts = append(elts, &Edge{sip, sni, "tlsrequest"})
+
+		par := ExtractDomain(sni)
+		if par != "" {
+			elts = append(elts, &Node{par, "domain"})
+			elts = append(elts, &Edge{sni, par, "indomain"})
+		}
+
+		elts = append(elts, HostnameProperties(sni)...)
+
+	}
+
+	// Certificates are sent by the server, so the server is the source.
+	if e.Action == "tls_certificates" && e.Detail.Tls != nil &&
+		len(e.Detail.Tls.Certificates) > 0 {
+
+		cn, err := ParseCertificate(e.Detail.Tls.Certificates[0])
+		if err == nil {
+
+			subject := cn.Names[0]
+
+			for _, v := range cn.Names {
+				elts = append(elts, &Node{v, "certificate"})
+				elts = append(elts, &Edge{sip, v, "tlscert"})
+				elts = append(elts, DescribeCertName(v)...)
+				if v != subject {
+					elts = append(elts,
+						&Edge{subject, v, "altname"})
+				}
+			}
+
+			if cn.Issuer != "" && cn.Issuer != subject {
+				elts = append(elts,
+					&Node{cn.Issuer, "certificate"})
+				elts = append(elts,
+					&Edge{subject, cn.Issuer, "issuedby"})
+			}
+
+		}
+
+	}
+
+	if len(Options.SketchGroups) > 0 {
+		elts = append(elts, DescribeNeighbours(elts)...)
+	}
+
 	return elts, tm, nil
         
 }
 
-func DescribeThreatGraph(e dt.Event) (interface{}, error) {
+// Neighbour sketch updates for the edges in a set of elements, for the
+// edge groups in Options.SketchGroups.  Each end of an edge is counted
+// against the nodes named by the other end.
+func DescribeNeighbours(elts []Summarisable) []Summarisable {
 
-	s := NewSummary()
-
-	res, tm, _ := DescribeThreatElements(e)
-	for _, v := range res {
-		v.Update(&s, tm)
+	groups := map[string][]string{}
+	for _, v := range elts {
+		if n, ok := v.(*Node); ok {
+			groups[n.Name] = append(groups[n.Name], n.Group)
+		}
 	}
 
-	g, _ := s.ToGraph()
+	res := []Summarisable{}
+	for _, v := range elts {
+		e, ok := v.(*Edge)
+		if !ok || !Options.SketchGroups[e.Group] {
+			continue
+		}
+		for _, g := range groups[e.Source] {
+			res = append(res, &NodeNeighbour{Node{e.Source, g},
+				e.Group + "Destinations", e.Destination})
+		}
+		for _, g := range groups[e.Destination] {
+			res = append(res, &NodeNeighbour{Node{e.Destination, g},
+				e.Group + "Sources", e.Source})
+		}
+	}
 
-	return g, nil
+	return res
 
 }
 
-/*
+// Service exposed by the server end of a flow.  Events are seen in both
+// directions, so the server is taken to be the end with the lower port,
+// the destination if they're the same.  ICMP has no service.
+func DescribeService(src, dst Address, network string) []Summarisable {
 
-func main() {
+	if dst.Proto != "tcp" && dst.Proto != "udp" {
+		return nil
+	}
 
-	file := "data.json"
-	f, err := os.Open(file)
-	if err != nil {
-		fmt.Printf("Couldn't read %s: %s\n", file, err.Error())
-		os.Exit(1)
+	server := dst
+	if src.Port < dst.Port {
+		server = src
 	}
-	data, err := ioutil.ReadAll(f)
-	if err != nil {
-		fmt.Printf("Couldn't read file: %s\n", err.Error())
-		os.Exit(1)
+
+	svc := server.Proto + "/" + strconv.Itoa(server.Port)
+
+	return []Summarisable{
+		&Node{svc, "service"},
+		&Edge{IPVertex(server.Addr(), network), svc, "exposes"},
 	}
-	f.Close()
 
-	var es []dt.Event
-	err = json.Unmarshal(data, &es)
-	if err != nil {
-		fmt.Printf("Couldn't unmarshal JSON from file: %s\n",
-			err.Error())
+}
+
+// Reduce an HTTP status code to its class e.g. 404 -> 4xx.
+func StatusClass(code int) string {
+	if code < 100 || code > 599 {
+		return ""
 	}
+	return strconv.Itoa(code/100) + "xx"
+}
 
-	for _, v := range es {
+// Media type from a Content-Type header, without parameters.
+func ContentType(s string) string {
+	ix := strings.IndexAny(s, ";")
+	if ix >= 0 {
+		s = s[:ix]
+	}
+	return strings.ToLower(strings.TrimSpace(s))
+}
 
-		g, _  := DescribeThreatGraph(v)
+func DescribeThreatGraph(e Event) (interface{}, error) {
 
-		b, _ := json.MarshalIndent(g, "", "  ")
-		fmt.Println(string(b))
+	s := NewSummary()
 
+	res, tm, _ := DescribeThreatElements(e)
+	for _, v := range res {
+		v.Update(&s, tm)
 	}
 
-}
+	// Always ordered, so output can be compared.
+	g, _ := s.toGraph(true)
 
-*/
+	return g, nil
+
+}
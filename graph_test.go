@@ -2,15 +2,24 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"testing"
 	"encoding/json"
 	dt "github.com/trustnetworks/analytics-common/datatypes"
+	"math/big"
 	"reflect"
+	"strconv"
+	"time"
 )
 
 type Check func(*testing.T, dt.Bundle)
 
-func RunChecks(t *testing.T, e dt.Event, r []dt.Bundle) {
+func RunChecks(t *testing.T, e Event, r []interface{}) {
 
 	g, err := DescribeThreatGraph(e)
 	if err != nil {
@@ -31,13 +40,14 @@ func RunChecks(t *testing.T, e dt.Event, r []dt.Bundle) {
 	
 
 	if string(enc) != string(enc2) {
-		t.Errorf("Expected doesn't match generated")
+		t.Errorf("Expected doesn't match generated: %s, %s",
+			enc, enc2)
 	}
 }
 
 func Compare(t *testing.T, in string, exp []Summarisable, exper string) {
 	
-	var e dt.Event
+	var e Event
 	err := json.Unmarshal([]byte(in), &e)
 	if err != nil {
 		t.Errorf("Couldn't decode JSON: %s", err.Error())
@@ -75,11 +85,18 @@ func TestGraph(t *testing.T) {
 		&Node{"10.0.2.15", "ip"},
 		&Node{"93.184.216.34", "ip"},
 		&Edge{"10.0.2.15", "93.184.216.34", "ipflow"},
+		&EdgeProperty{Edge{"10.0.2.15", "93.184.216.34", "ipflow"},
+			"protocol", "tcp"},
 
 		// Device
 		&Node{"debug", "device"},
 		&Edge{"debug", "10.0.2.15", "hasip"},
 
+		// Network
+		&Node{"test-lan", "network"},
+		&Edge{"debug", "test-lan", "innetwork"},
+		&Edge{"10.0.2.15", "test-lan", "innetwork"},
+
 		// UA
 		&Edge{"10.0.2.15", "Wget/1.19.5 (linux-gnu)", "useragent"},
 
@@ -106,11 +123,18 @@ func TestGraph(t *testing.T) {
 		&Node{"10.0.2.15", "ip"},
 		&Node{"8.8.8.8", "ip"},
 		&Edge{"10.0.2.15", "8.8.8.8", "ipflow"},
+		&EdgeProperty{Edge{"10.0.2.15", "8.8.8.8", "ipflow"},
+			"protocol", "udp"},
 
 		// Device
 		&Node{"debug", "device"},
 		&Edge{"debug", "10.0.2.15", "hasip"},
 
+		// Network
+		&Node{"test-lan", "network"},
+		&Edge{"debug", "test-lan", "innetwork"},
+		&Edge{"10.0.2.15", "test-lan", "innetwork"},
+
 		// DNS query info
 		&Node{"www.example.org", "hostname"},
 		&Edge{"10.0.2.15", "www.example.org", "dnsquery"},
@@ -134,11 +158,18 @@ func TestGraph(t *testing.T) {
 		&Node{"10.0.2.15", "ip"},
 		&Node{"93.184.216.34", "ip"},
 		&Edge{"10.0.2.15", "93.184.216.34", "ipflow"},
+		&EdgeProperty{Edge{"10.0.2.15", "93.184.216.34", "ipflow"},
+			"protocol", "tcp"},
 
 		// Device
 		&Node{"debug", "device"},
 		&Edge{"debug", "10.0.2.15", "hasip"},
 
+		// Network
+		&Node{"test-lan", "network"},
+		&Edge{"debug", "test-lan", "innetwork"},
+		&Edge{"10.0.2.15", "test-lan", "innetwork"},
+
 		// UA
 		&Edge{"10.0.2.15", "Wget/1.19.5 (linux-gnu)", "useragent"},
 
@@ -165,11 +196,18 @@ func TestGraph(t *testing.T) {
 		&Node{"10.0.2.15", "ip"},
 		&Node{"8.8.8.8", "ip"},
 		&Edge{"10.0.2.15", "8.8.8.8", "ipflow"},
+		&EdgeProperty{Edge{"10.0.2.15", "8.8.8.8", "ipflow"},
+			"protocol", "udp"},
 
 		// Device
 		&Node{"debug", "device"},
 		&Edge{"debug", "10.0.2.15", "hasip"},
 
+		// Network
+		&Node{"test-lan", "network"},
+		&Edge{"debug", "test-lan", "innetwork"},
+		&Edge{"10.0.2.15", "test-lan", "innetwork"},
+
 		// DNS response
 		&Node{"www.example.org", "hostname"},
 		&Node{"9.10.11.12", "ip"},
@@ -194,11 +232,18 @@ func TestGraph(t *testing.T) {
 		&Node{"10.0.2.15", "ip"},
 		&Node{"93.184.216.34", "ip"},
 		&Edge{"10.0.2.15", "93.184.216.34", "ipflow"},
+		&EdgeProperty{Edge{"10.0.2.15", "93.184.216.34", "ipflow"},
+			"protocol", "tcp"},
 
 		// Device
 		&Node{"debug", "device"},
 		&Edge{"debug", "10.0.2.15", "hasip"},
 
+		// Network
+		&Node{"test-lan", "network"},
+		&Edge{"debug", "test-lan", "innetwork"},
+		&Edge{"10.0.2.15", "test-lan", "innetwork"},
+
 		// UA
 		&Edge{"10.0.2.15", "Wget/1.19.5 (linux-gnu)", "useragent"},
 
@@ -224,11 +269,18 @@ func TestGraph(t *testing.T) {
 		&Node{"10.0.2.15", "ip"},
 		&Node{"93.184.216.34", "ip"},
 		&Edge{"10.0.2.15", "93.184.216.34", "ipflow"},
+		&EdgeProperty{Edge{"10.0.2.15", "93.184.216.34", "ipflow"},
+			"protocol", "tcp"},
 
 		// Device
 		&Node{"debug", "device"},
 		&Edge{"debug", "10.0.2.15", "hasip"},
 
+		// Network
+		&Node{"test-lan", "network"},
+		&Edge{"debug", "test-lan", "innetwork"},
+		&Edge{"10.0.2.15", "test-lan", "innetwork"},
+
 		// UA
 		&Edge{"10.0.2.15", "Wget/1.19.5 (linux-gnu)", "useragent"},
 
@@ -245,3 +297,685 @@ func TestGraph(t *testing.T) {
 
 }
 
+
+// Make a base64 DER certificate for TLS test cases.
+func makeCertificate(t *testing.T, cn string, sans []string,
+	issuer string) string {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Couldn't generate key: %s", err.Error())
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		Issuer:       pkix.Name{CommonName: issuer},
+		DNSNames:     sans,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	parent := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: issuer},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Couldn't create certificate: %s", err.Error())
+	}
+
+	return base64.StdEncoding.EncodeToString(der)
+
+}
+
+func TestTls(t *testing.T) {
+
+	// Case: TLS client hello with SNI
+	in1 := `
+{"network":"test-lan","origin":"device","dest":["ipv4:93.184.216.34","tcp:443"],"device":"debug","time":"2018-05-21T11:03:22.634Z","src":["ipv4:10.0.2.15","tcp:34062"],"action":"tls_client_hello","tls":{"version":"3.3","server_name":"www.example.org"},"id":"61106e53-a115-48bf-c881-e68619221237"}
+`
+
+	exp1 := []Summarisable{
+
+		// IP flow info
+		&Node{"10.0.2.15", "ip"},
+		&Node{"93.184.216.34", "ip"},
+		&Edge{"10.0.2.15", "93.184.216.34", "ipflow"},
+		&EdgeProperty{Edge{"10.0.2.15", "93.184.216.34", "ipflow"},
+			"protocol", "tcp"},
+
+		// Device
+		&Node{"debug", "device"},
+		&Edge{"debug", "10.0.2.15", "hasip"},
+
+		// Network
+		&Node{"test-lan", "network"},
+		&Edge{"debug", "test-lan", "innetwork"},
+		&Edge{"10.0.2.15", "test-lan", "innetwork"},
+
+		// SNI
+		&Node{"www.example.org", "hostname"},
+		&Edge{"10.0.2.15", "www.example.org", "tlsrequest"},
+
+		// Domain
+		&Node{"example.org", "domain"},
+		&Edge{"www.example.org", "example.org", "indomain"},
+	}
+
+	Compare(t, in1, exp1, "exp1")
+
+	// Case: server certificate chain
+	cert := makeCertificate(t, "www.example.org",
+		[]string{"www.example.org", "example.org"},
+		"DigiCert SHA2 Secure Server CA")
+
+	in2 := `
+{"network":"test-lan","origin":"network","dest":["ipv4:10.0.2.15","tcp:34062"],"device":"debug","time":"2018-05-21T11:03:22.734Z","src":["ipv4:93.184.216.34","tcp:443"],"action":"tls_certificates","tls":{"certificates":["` + cert + `"]},"id":"61106e53-a115-48bf-c881-e68619221238"}
+`
+
+	exp2 := []Summarisable{
+
+		// IP flow info
+		&Node{"93.184.216.34", "ip"},
+		&Node{"10.0.2.15", "ip"},
+		&Edge{"93.184.216.34", "10.0.2.15", "ipflow"},
+		&EdgeProperty{Edge{"93.184.216.34", "10.0.2.15", "ipflow"},
+			"protocol", "tcp"},
+
+		// Device
+		&Node{"debug", "device"},
+		&Edge{"debug", "10.0.2.15", "hasip"},
+
+		// Network
+		&Node{"test-lan", "network"},
+		&Edge{"debug", "test-lan", "innetwork"},
+		&Edge{"10.0.2.15", "test-lan", "innetwork"},
+
+		// Certificate names, and their hostnames
+		&Node{"www.example.org", "certificate"},
+		&Edge{"93.184.216.34", "www.example.org", "tlscert"},
+		&Node{"www.example.org", "hostname"},
+		&Node{"example.org", "domain"},
+		&Edge{"www.example.org", "example.org", "indomain"},
+		&Node{"example.org", "certificate"},
+		&Edge{"93.184.216.34", "example.org", "tlscert"},
+		&Node{"example.org", "hostname"},
+		&Node{"example.org", "domain"},
+		&Edge{"example.org", "example.org", "indomain"},
+		&Edge{"www.example.org", "example.org", "altname"},
+
+		// Issuer
+		&Node{"DigiCert SHA2 Secure Server CA", "certificate"},
+		&Edge{"www.example.org", "DigiCert SHA2 Secure Server CA",
+			"issuedby"},
+	}
+
+	Compare(t, in2, exp2, "exp2")
+
+	// Case: undecodable certificate leaves just the flow
+	in3 := `
+{"network":"test-lan","dest":["ipv4:10.0.2.15","tcp:34062"],"device":"debug","time":"2018-05-21T11:03:22.734Z","src":["ipv4:93.184.216.34","tcp:443"],"action":"tls_certificates","tls":{"certificates":["bm90IGEgY2VydA=="]},"id":"61106e53-a115-48bf-c881-e68619221239"}
+`
+
+	exp3 := []Summarisable{
+		&Node{"93.184.216.34", "ip"},
+		&Node{"10.0.2.15", "ip"},
+		&Edge{"93.184.216.34", "10.0.2.15", "ipflow"},
+		&EdgeProperty{Edge{"93.184.216.34", "10.0.2.15", "ipflow"},
+			"protocol", "tcp"},
+	}
+
+	Compare(t, in3, exp3, "exp3")
+
+	// Case: wildcard and organisation names
+	cert = makeCertificate(t, "Example Widgets Ltd",
+		[]string{"*.Example.ORG"}, "Example CA")
+
+	in4 := `
+{"network":"test-lan","dest":["ipv4:10.0.2.15","tcp:34062"],"device":"debug","time":"2018-05-21T11:03:22.734Z","src":["ipv4:93.184.216.34","tcp:443"],"action":"tls_certificates","tls":{"certificates":["` + cert + `"]},"id":"61106e53-a115-48bf-c881-e6861922123a"}
+`
+
+	exp4 := []Summarisable{
+		&Node{"93.184.216.34", "ip"},
+		&Node{"10.0.2.15", "ip"},
+		&Edge{"93.184.216.34", "10.0.2.15", "ipflow"},
+		&EdgeProperty{Edge{"93.184.216.34", "10.0.2.15", "ipflow"},
+			"protocol", "tcp"},
+
+		// Organisation, not a host
+		&Node{"Example Widgets Ltd", "certificate"},
+		&Edge{"93.184.216.34", "Example Widgets Ltd", "tlscert"},
+
+		// Wildcard, linked to its domain
+		&Node{"*.example.org", "certificate"},
+		&Edge{"93.184.216.34", "*.example.org", "tlscert"},
+		&Node{"example.org", "domain"},
+		&Edge{"example.org", "*.example.org", "covers"},
+		&Edge{"Example Widgets Ltd", "*.example.org", "altname"},
+
+		&Node{"Example CA", "certificate"},
+		&Edge{"Example Widgets Ltd", "Example CA", "issuedby"},
+	}
+
+	Compare(t, in4, exp4, "exp4")
+
+}
+
+func TestHttpResponse(t *testing.T) {
+
+	// Case: HTTP response serving an executable
+	in1 := `
+{"network":"test-lan","origin":"network","dest":["ipv4:10.0.2.15","tcp:34060","http"],"device":"debug","time":"2018-05-21T11:03:22.834Z","src":["ipv4:93.184.216.34","tcp:80","http"],"http_response":{"code":200,"status":"OK","header":{"Server":"nginx/1.14.0","Content-Type":"Application/x-dosexec; charset=binary","Content-Length":"73802"}},"action":"http_response","id":"61106e53-a115-48bf-c881-e68619221240","url":"http:\/\/www.example.org\/setup.exe"}
+`
+
+	exp1 := []Summarisable{
+
+		// IP flow info
+		&Node{"93.184.216.34", "ip"},
+		&Node{"10.0.2.15", "ip"},
+		&Edge{"93.184.216.34", "10.0.2.15", "ipflow"},
+		&EdgeProperty{Edge{"93.184.216.34", "10.0.2.15", "ipflow"},
+			"protocol", "tcp"},
+
+		// Device
+		&Node{"debug", "device"},
+		&Edge{"debug", "10.0.2.15", "hasip"},
+
+		// Network
+		&Node{"test-lan", "network"},
+		&Edge{"debug", "test-lan", "innetwork"},
+		&Edge{"10.0.2.15", "test-lan", "innetwork"},
+
+		// Server software
+		&Node{"nginx/1.14.0", "serverSoftware"},
+		&Edge{"93.184.216.34", "nginx/1.14.0", "runs"},
+
+		// Status
+		&Node{"2xx", "httpstatus"},
+		&Edge{"93.184.216.34", "2xx", "returned"},
+
+		// Content type
+		&Node{"application/x-dosexec", "contenttype"},
+		&Edge{"93.184.216.34", "application/x-dosexec", "served"},
+	}
+
+	Compare(t, in1, exp1, "exp1")
+
+	// Case: error with no headers, lower-case header names
+	in2 := `
+{"network":"test-lan","dest":["ipv4:10.0.2.15","tcp:34060","http"],"device":"debug","time":"2018-05-21T11:03:22.834Z","src":["ipv4:93.184.216.34","tcp:80","http"],"http_response":{"code":503,"status":"Service Unavailable","header":{"server":"cloudflare"}},"action":"http_response","id":"61106e53-a115-48bf-c881-e68619221241"}
+`
+
+	exp2 := []Summarisable{
+		&Node{"93.184.216.34", "ip"},
+		&Node{"10.0.2.15", "ip"},
+		&Edge{"93.184.216.34", "10.0.2.15", "ipflow"},
+		&EdgeProperty{Edge{"93.184.216.34", "10.0.2.15", "ipflow"},
+			"protocol", "tcp"},
+		&Node{"cloudflare", "serverSoftware"},
+		&Edge{"93.184.216.34", "cloudflare", "runs"},
+		&Node{"5xx", "httpstatus"},
+		&Edge{"93.184.216.34", "5xx", "returned"},
+	}
+
+	Compare(t, in2, exp2, "exp2")
+
+}
+
+func TestDnsRecords(t *testing.T) {
+
+	// Case: CNAME chain to a CDN, with AAAA answer.  edgekey.net is
+	// a private public suffix.
+	in1 := `
+{"time":"2018-05-21T09:19:10.045Z","id":"d346b188-e2c0-4e08-ce64-4528b33d6359","dns_message":{"query":[{"type":"AAAA","class":"IN","name":"www.example.org"}],"answer":[{"type":"CNAME","class":"IN","name":"www.example.org","data":"www.example.org.edgekey.net"},{"type":"AAAA","class":"IN","name":"www.example.org.edgekey.net","address":"2606:2800:220:1:248:1893:25c8:1946"}],"type":"response"},"action":"dns_message","dest":["ipv4:10.0.2.15","udp:45465","dns"],"network":"test-lan","src":["ipv4:8.8.8.8","udp:53","dns"],"device":"debug"}
+`
+	exp1 := []Summarisable{
+
+		// IP flow info
+		&Node{"8.8.8.8", "ip"},
+		&Node{"10.0.2.15", "ip"},
+		&Edge{"8.8.8.8", "10.0.2.15", "ipflow"},
+		&EdgeProperty{Edge{"8.8.8.8", "10.0.2.15", "ipflow"},
+			"protocol", "udp"},
+
+		// CNAME
+		&Node{"www.example.org", "hostname"},
+		&Node{"example.org", "domain"},
+		&Edge{"www.example.org", "example.org", "indomain"},
+		&Node{"www.example.org.edgekey.net", "hostname"},
+		&Node{"org.edgekey.net", "domain"},
+		&Edge{"www.example.org.edgekey.net", "org.edgekey.net",
+			"indomain"},
+		&Edge{"www.example.org", "www.example.org.edgekey.net", "cname"},
+
+		// AAAA
+		&Node{"www.example.org.edgekey.net", "hostname"},
+		&Node{"2606:2800:220:1:248:1893:25c8:1946", "ip"},
+		&Edge{"www.example.org.edgekey.net",
+			"2606:2800:220:1:248:1893:25c8:1946", "dns"},
+		&Node{"org.edgekey.net", "domain"},
+		&Edge{"www.example.org.edgekey.net", "org.edgekey.net",
+			"indomain"},
+	}
+
+	Compare(t, in1, exp1, "exp1")
+
+	// Case: MX answer, NS delegation in authority, TXT
+	in2 := `
+{"time":"2018-05-21T09:19:10.045Z","id":"d346b188-e2c0-4e08-ce64-4528b33d6360","dns_message":{"query":[{"type":"MX","class":"IN","name":"example.org"}],"answer":[{"type":"MX","class":"IN","name":"example.org","data":"10 mail.example.org"},{"type":"TXT","class":"IN","name":"example.org","data":"v=spf1 -all"}],"authority":[{"type":"NS","class":"IN","name":"example.org","data":"a.iana-servers.net"}],"type":"response"},"action":"dns_message","dest":["ipv4:10.0.2.15","udp:45465","dns"],"network":"test-lan","src":["ipv4:8.8.8.8","udp:53","dns"],"device":"debug"}
+`
+	exp2 := []Summarisable{
+
+		// IP flow info
+		&Node{"8.8.8.8", "ip"},
+		&Node{"10.0.2.15", "ip"},
+		&Edge{"8.8.8.8", "10.0.2.15", "ipflow"},
+		&EdgeProperty{Edge{"8.8.8.8", "10.0.2.15", "ipflow"},
+			"protocol", "udp"},
+
+		// MX
+		&Node{"example.org", "hostname"},
+		&Node{"example.org", "domain"},
+		&Edge{"example.org", "example.org", "indomain"},
+		&Node{"mail.example.org", "hostname"},
+		&Node{"example.org", "domain"},
+		&Edge{"mail.example.org", "example.org", "indomain"},
+		&Edge{"example.org", "mail.example.org", "mx"},
+
+		// TXT
+		&Node{"example.org", "hostname"},
+		&Node{"example.org", "domain"},
+		&Edge{"example.org", "example.org", "indomain"},
+		&NodeProperty{Node{"example.org", "hostname"}, "txt",
+			"v=spf1 -all"},
+
+		// NS
+		&Node{"example.org", "hostname"},
+		&Node{"example.org", "domain"},
+		&Edge{"example.org", "example.org", "indomain"},
+		&Node{"a.iana-servers.net", "hostname"},
+		&Node{"iana-servers.net", "domain"},
+		&Edge{"a.iana-servers.net", "iana-servers.net", "indomain"},
+		&Edge{"example.org", "a.iana-servers.net", "ns"},
+	}
+
+	Compare(t, in2, exp2, "exp2")
+
+	// Case: Null MX (RFC 7505) and a root CNAME aren't hosts
+	in3 := `
+{"time":"2018-05-21T09:19:10.045Z","id":"d346b188-e2c0-4e08-ce64-4528b33d6363","dns_message":{"query":[{"type":"MX","class":"IN","name":"example.org"}],"answer":[{"type":"MX","class":"IN","name":"example.org","data":"0 ."},{"type":"CNAME","class":"IN","name":"www.example.org","data":"."}],"type":"response"},"action":"dns_message","dest":["ipv4:10.0.2.15","udp:45465","dns"],"network":"test-lan","src":["ipv4:8.8.8.8","udp:53","dns"],"device":"debug"}
+`
+	exp3 := []Summarisable{
+
+		// IP flow info
+		&Node{"8.8.8.8", "ip"},
+		&Node{"10.0.2.15", "ip"},
+		&Edge{"8.8.8.8", "10.0.2.15", "ipflow"},
+		&EdgeProperty{Edge{"8.8.8.8", "10.0.2.15", "ipflow"},
+			"protocol", "udp"},
+	}
+
+	Compare(t, in3, exp3, "exp3")
+
+}
+
+func TestDnsFailure(t *testing.T) {
+
+	// Case: NXDOMAIN, numeric rcode
+	in1 := `
+{"time":"2018-05-21T09:19:10.045Z","id":"d346b188-e2c0-4e08-ce64-4528b33d6361","dns_message":{"query":[{"type":"A","class":"IN","name":"qxkvbnweu.example.org"}],"answer":[],"rcode":3,"type":"response"},"action":"dns_message","dest":["ipv4:10.0.2.15","udp:45465","dns"],"network":"test-lan","src":["ipv4:8.8.8.8","udp:53","dns"],"device":"debug"}
+`
+	exp1 := []Summarisable{
+
+		// IP flow info
+		&Node{"8.8.8.8", "ip"},
+		&Node{"10.0.2.15", "ip"},
+		&Edge{"8.8.8.8", "10.0.2.15", "ipflow"},
+		&EdgeProperty{Edge{"8.8.8.8", "10.0.2.15", "ipflow"},
+			"protocol", "udp"},
+
+		// Failure
+		&Node{"qxkvbnweu.example.org", "hostname"},
+		&Node{"example.org", "domain"},
+		&Edge{"qxkvbnweu.example.org", "example.org", "indomain"},
+		&Edge{"10.0.2.15", "qxkvbnweu.example.org", "dnsfailure"},
+		&EdgeProperty{Edge{"10.0.2.15", "qxkvbnweu.example.org",
+			"dnsfailure"}, "rcode", "NXDOMAIN"},
+	}
+
+	Compare(t, in1, exp1, "exp1")
+
+	// Case: NOERROR is not a failure
+	in2 := `
+{"time":"2018-05-21T09:19:10.045Z","id":"d346b188-e2c0-4e08-ce64-4528b33d6362","dns_message":{"query":[{"type":"A","class":"IN","name":"www.example.org"}],"answer":[],"rcode":"noerror","type":"response"},"action":"dns_message","dest":["ipv4:10.0.2.15","udp:45465","dns"],"network":"test-lan","src":["ipv4:8.8.8.8","udp:53","dns"],"device":"debug"}
+`
+	exp2 := []Summarisable{
+		&Node{"8.8.8.8", "ip"},
+		&Node{"10.0.2.15", "ip"},
+		&Edge{"8.8.8.8", "10.0.2.15", "ipflow"},
+		&EdgeProperty{Edge{"8.8.8.8", "10.0.2.15", "ipflow"},
+			"protocol", "udp"},
+	}
+
+	Compare(t, in2, exp2, "exp2")
+
+	// rcode is carried into the Gaffer edge.
+	s := NewSummary()
+	for _, v := range exp1 {
+		v.Update(&s, time.Now())
+	}
+	st := s.Edges[Edge{"10.0.2.15", "qxkvbnweu.example.org", "dnsfailure"}]
+	if st == nil || st.Count != 1 || !st.Props["rcode"]["NXDOMAIN"] {
+		t.Errorf("dnsfailure edge state not as expected: %v", st)
+	}
+
+}
+
+func TestIdn(t *testing.T) {
+
+	// Case: DNS query for a mixed-script name, upper case with
+	// trailing dot
+	in1 := `
+{"time":"2018-05-21T09:19:10.045Z","id":"d346b188-e2c0-4e08-ce64-4528b33d6363","dns_message":{"query":[{"type":"A","class":"IN","name":"WWW.P\u0430YPAL.com."}],"answer":[],"type":"query"},"action":"dns_message","dest":["ipv4:8.8.8.8","udp:53","dns"],"network":"test-lan","src":["ipv4:10.0.2.15","udp:45465","dns"],"device":"debug"}
+`
+	exp1 := []Summarisable{
+
+		// IP flow info
+		&Node{"10.0.2.15", "ip"},
+		&Node{"8.8.8.8", "ip"},
+		&Edge{"10.0.2.15", "8.8.8.8", "ipflow"},
+		&EdgeProperty{Edge{"10.0.2.15", "8.8.8.8", "ipflow"},
+			"protocol", "udp"},
+
+		// DNS query info
+		&Node{"www.xn--pypal-4ve.com", "hostname"},
+		&Edge{"10.0.2.15", "www.xn--pypal-4ve.com", "dnsquery"},
+
+		// Domain
+		&Node{"xn--pypal-4ve.com", "domain"},
+		&Edge{"www.xn--pypal-4ve.com", "xn--pypal-4ve.com", "indomain"},
+
+		// IDN properties
+		&NodeProperty{Node{"www.xn--pypal-4ve.com", "hostname"},
+			"display", "www.p\u0430ypal.com"},
+		&NodeProperty{Node{"www.xn--pypal-4ve.com", "hostname"},
+			"homograph", "true"},
+	}
+
+	Compare(t, in1, exp1, "exp1")
+
+	// Case: Host header case differs
+	in2 := `
+{"network":"test-lan","dest":["ipv4:93.184.216.34","tcp:80","http"],"device":"debug","time":"2018-05-21T11:03:22.634Z","src":["ipv4:10.0.2.15","tcp:34060","http"],"http_request":{"header":{"Host":"WWW.Example.ORG"},"method":"GET"},"action":"http_request","id":"61106e53-a115-48bf-c881-e68619221242"}
+`
+
+	exp2 := []Summarisable{
+		&Node{"10.0.2.15", "ip"},
+		&Node{"93.184.216.34", "ip"},
+		&Edge{"10.0.2.15", "93.184.216.34", "ipflow"},
+		&EdgeProperty{Edge{"10.0.2.15", "93.184.216.34", "ipflow"},
+			"protocol", "tcp"},
+		&Node{"www.example.org", "server"},
+		&Edge{"10.0.2.15", "www.example.org", "webrequest"},
+		&Edge{"93.184.216.34", "www.example.org", "serves"},
+		&Node{"example.org", "domain"},
+		&Edge{"www.example.org", "example.org", "indomain"},
+	}
+
+	Compare(t, in2, exp2, "exp2")
+
+}
+
+func TestReverseDns(t *testing.T) {
+
+	// Case: PTR query
+	in1 := `
+{"time":"2018-05-21T09:19:10.045Z","id":"d346b188-e2c0-4e08-ce64-4528b33d6364","dns_message":{"query":[{"type":"PTR","class":"IN","name":"34.216.184.93.in-addr.arpa"}],"answer":[],"type":"query"},"action":"dns_message","dest":["ipv4:8.8.8.8","udp:53","dns"],"network":"test-lan","src":["ipv4:10.0.2.15","udp:45465","dns"],"device":"debug"}
+`
+	exp1 := []Summarisable{
+
+		// IP flow info
+		&Node{"10.0.2.15", "ip"},
+		&Node{"8.8.8.8", "ip"},
+		&Edge{"10.0.2.15", "8.8.8.8", "ipflow"},
+		&EdgeProperty{Edge{"10.0.2.15", "8.8.8.8", "ipflow"},
+			"protocol", "udp"},
+
+		// Reverse lookup
+		&Node{"93.184.216.34", "ip"},
+		&Edge{"10.0.2.15", "93.184.216.34", "reverselookup"},
+	}
+
+	Compare(t, in1, exp1, "exp1")
+
+	// Case: PTR response for an IPv6 address
+	in2 := `
+{"time":"2018-05-21T09:19:10.045Z","id":"d346b188-e2c0-4e08-ce64-4528b33d6365","dns_message":{"query":[{"type":"PTR","class":"IN","name":"6.4.9.1.8.c.5.2.3.9.8.1.8.4.2.0.1.0.0.0.0.2.2.0.0.0.8.2.6.0.6.2.ip6.arpa"}],"answer":[{"type":"PTR","class":"IN","name":"6.4.9.1.8.c.5.2.3.9.8.1.8.4.2.0.1.0.0.0.0.2.2.0.0.0.8.2.6.0.6.2.ip6.arpa","data":"www.example.org."}],"type":"response"},"action":"dns_message","dest":["ipv4:10.0.2.15","udp:45465","dns"],"network":"test-lan","src":["ipv4:8.8.8.8","udp:53","dns"],"device":"debug"}
+`
+	exp2 := []Summarisable{
+
+		// IP flow info
+		&Node{"8.8.8.8", "ip"},
+		&Node{"10.0.2.15", "ip"},
+		&Edge{"8.8.8.8", "10.0.2.15", "ipflow"},
+		&EdgeProperty{Edge{"8.8.8.8", "10.0.2.15", "ipflow"},
+			"protocol", "udp"},
+
+		// PTR
+		&Node{"2606:2800:220:1:248:1893:25c8:1946", "ip"},
+		&Node{"www.example.org", "hostname"},
+		&Node{"example.org", "domain"},
+		&Edge{"www.example.org", "example.org", "indomain"},
+		&Edge{"2606:2800:220:1:248:1893:25c8:1946", "www.example.org",
+			"ptr"},
+	}
+
+	Compare(t, in2, exp2, "exp2")
+
+	// Partial reverse names have no domain.
+	if d := ExtractDomain("216.184.93.in-addr.arpa"); d != "" {
+		t.Errorf("Reverse name has domain %s", d)
+	}
+
+}
+
+func TestServices(t *testing.T) {
+
+	Options.Services = true
+	defer func() { Options.Services = false }()
+
+	// Case: DNS response, server is the source
+	in1 := `
+{"time":"2018-05-21T09:19:10.045Z","id":"d346b188-e2c0-4e08-ce64-4528b33d6366","dns_message":{"query":[{"type":"A","class":"IN","name":"www.example.org"}],"answer":[],"type":"response"},"action":"dns_message","dest":["ipv4:10.0.2.15","udp:45465","dns"],"network":"test-lan","src":["ipv4:8.8.8.8","udp:53","dns"],"device":"debug"}
+`
+	exp1 := []Summarisable{
+		&Node{"8.8.8.8", "ip"},
+		&Node{"10.0.2.15", "ip"},
+		&Edge{"8.8.8.8", "10.0.2.15", "ipflow"},
+		&EdgeProperty{Edge{"8.8.8.8", "10.0.2.15", "ipflow"},
+			"protocol", "udp"},
+		&Node{"udp/53", "service"},
+		&Edge{"8.8.8.8", "udp/53", "exposes"},
+	}
+
+	Compare(t, in1, exp1, "exp1")
+
+	// Case: ICMP has no service
+	in2 := `
+{"time":"2018-05-21T09:19:10.045Z","id":"d346b188-e2c0-4e08-ce64-4528b33d6367","action":"icmp","dest":["ipv4:8.8.8.8","icmp"],"network":"test-lan","src":["ipv4:10.0.2.15","icmp"],"device":"debug"}
+`
+	exp2 := []Summarisable{
+		&Node{"10.0.2.15", "ip"},
+		&Node{"8.8.8.8", "ip"},
+		&Edge{"10.0.2.15", "8.8.8.8", "ipflow"},
+		&EdgeProperty{Edge{"10.0.2.15", "8.8.8.8", "ipflow"},
+			"protocol", "icmp"},
+	}
+
+	Compare(t, in2, exp2, "exp2")
+
+}
+
+func TestScopePrivate(t *testing.T) {
+
+	Options.ScopePrivate = true
+	defer func() { Options.ScopePrivate = false }()
+
+	// Case: DNS response giving a private address
+	in1 := `
+{"time":"2018-05-21T09:19:10.045Z","id":"d346b188-e2c0-4e08-ce64-4528b33d6368","dns_message":{"query":[{"type":"A","class":"IN","name":"intranet.example.org"}],"answer":[{"type":"A","class":"IN","name":"intranet.example.org","address":"10.0.0.80"}],"type":"response"},"action":"dns_message","dest":["ipv4:10.0.2.15","udp:45465","dns"],"network":"customer-a","origin":"network","src":["ipv4:8.8.8.8","udp:53","dns"],"device":"laptop"}
+`
+	exp1 := []Summarisable{
+
+		// IP flow info, public address isn't scoped
+		&Node{"8.8.8.8", "ip"},
+		&Node{"10.0.2.15%customer-a", "ip"},
+		&Edge{"8.8.8.8", "10.0.2.15%customer-a", "ipflow"},
+		&EdgeProperty{Edge{"8.8.8.8", "10.0.2.15%customer-a", "ipflow"},
+			"protocol", "udp"},
+
+		// Device
+		&Node{"laptop", "device"},
+		&Edge{"laptop", "10.0.2.15%customer-a", "hasip"},
+
+		// Network
+		&Node{"customer-a", "network"},
+		&Edge{"laptop", "customer-a", "innetwork"},
+		&Edge{"10.0.2.15%customer-a", "customer-a", "innetwork"},
+
+		// DNS response
+		&Node{"intranet.example.org", "hostname"},
+		&Node{"10.0.0.80%customer-a", "ip"},
+		&Edge{"intranet.example.org", "10.0.0.80%customer-a", "dns"},
+		&Node{"example.org", "domain"},
+		&Edge{"intranet.example.org", "example.org", "indomain"},
+	}
+
+	Compare(t, in1, exp1, "exp1")
+
+	if v := IPVertex("fd00::1", "customer-b"); v != "fd00::1%customer-b" {
+		t.Errorf("IPv6 unique local not scoped: %s", v)
+	}
+	if v := IPVertex("172.32.0.1", "customer-b"); v != "172.32.0.1" {
+		t.Errorf("Public address scoped: %s", v)
+	}
+
+}
+
+func TestVolume(t *testing.T) {
+
+	// Case: connection volume
+	in1 := `
+{"network":"test-lan","dest":["ipv4:93.184.216.34","tcp:443"],"device":"debug","time":"2018-05-21T11:03:22.634Z","src":["ipv4:10.0.2.15","tcp:34062"],"action":"connected_down","volume":{"bytes":48213,"packets":41,"duration":2.5},"id":"61106e53-a115-48bf-c881-e68619221243"}
+`
+	exp1 := []Summarisable{
+		&Node{"10.0.2.15", "ip"},
+		&Node{"93.184.216.34", "ip"},
+		&Edge{"10.0.2.15", "93.184.216.34", "ipflow"},
+		&EdgeProperty{Edge{"10.0.2.15", "93.184.216.34", "ipflow"},
+			"protocol", "tcp"},
+		&EdgeVolume{Edge{"10.0.2.15", "93.184.216.34", "ipflow"},
+			Volume{Bytes: 48213, Packets: 41, Duration: 2.5}},
+	}
+
+	Compare(t, in1, exp1, "exp1")
+
+	// Volumes accumulate with sum, min and max.
+	s := NewSummary()
+	flow := Edge{"10.0.2.15", "93.184.216.34", "ipflow"}
+	for _, v := range []Volume{{100, 2, 0.5}, {50, 1, 0}, {400, 5, 1.25}} {
+		(&EdgeVolume{flow, v}).Update(&s, time.Now())
+	}
+	vol := s.Edges[flow].Volume
+	if vol.Bytes != (Measure{550, 50, 400}) ||
+		vol.Packets != (Measure{8, 1, 5}) ||
+		vol.Duration != (Measure{1750, 0, 1250}) {
+		t.Errorf("Volume not accumulated: %v", *vol)
+	}
+
+}
+
+func TestNeighbourSketches(t *testing.T) {
+
+	Options.SketchGroups = map[string]bool{"dnsquery": true}
+	defer func() { Options.SketchGroups = nil }()
+
+	in1 := `
+{"time":"2018-05-21T09:19:10.045Z","id":"d346b188-e2c0-4e08-ce64-4528b33d6369","dns_message":{"query":[{"type":"A","class":"IN","name":"www.example.org"}],"answer":[],"type":"query"},"action":"dns_message","dest":["ipv4:8.8.8.8","udp:53","dns"],"network":"test-lan","src":["ipv4:10.0.2.15","udp:45465","dns"],"device":"debug"}
+`
+	exp1 := []Summarisable{
+		&Node{"10.0.2.15", "ip"},
+		&Node{"8.8.8.8", "ip"},
+		&Edge{"10.0.2.15", "8.8.8.8", "ipflow"},
+		&EdgeProperty{Edge{"10.0.2.15", "8.8.8.8", "ipflow"},
+			"protocol", "udp"},
+		&Node{"www.example.org", "hostname"},
+		&Edge{"10.0.2.15", "www.example.org", "dnsquery"},
+		&Node{"example.org", "domain"},
+		&Edge{"www.example.org", "example.org", "indomain"},
+
+		// Sketches, dnsquery only
+		&NodeNeighbour{Node{"10.0.2.15", "ip"}, "dnsqueryDestinations",
+			"www.example.org"},
+		&NodeNeighbour{Node{"www.example.org", "hostname"},
+			"dnsquerySources", "10.0.2.15"},
+	}
+
+	Compare(t, in1, exp1, "exp1")
+
+	// Many hostnames queried by one IP.
+	s := NewSummary()
+	for i := 0; i < 500; i++ {
+		(&NodeNeighbour{Node{"10.0.2.15", "ip"}, "dnsqueryDestinations",
+			"host" + strconv.Itoa(i) + ".example.org"}).
+			Update(&s, time.Now())
+	}
+	sk := s.Nodes[Node{"10.0.2.15", "ip"}].Sketches["dnsqueryDestinations"]
+	if c := sk.Cardinality(); c < 450 || c > 550 {
+		t.Errorf("Estimated %d distinct hostnames", c)
+	}
+
+}
+
+func TestPayload(t *testing.T) {
+
+	in1 := `
+{"time":"2018-05-21T09:19:10.045Z","id":"d346b188-e2c0-4e08-ce64-4528b33d6369","dns_message":{"query":[{"type":"A","class":"IN","name":"www.example.org"}],"answer":[],"type":"query"},"action":"dns_message","dest":["ipv4:8.8.8.8","udp:53","dns"],"network":"test-lan","src":["ipv4:10.0.2.15","udp:45465","dns"],"device":"debug"}
+`
+	var e Event
+	err := json.Unmarshal([]byte(in1), &e)
+	if err != nil {
+		t.Errorf("Couldn't decode JSON: %s", err.Error())
+	}
+
+	// Hour holding the event time.
+	tss := dt.NewTimestampSet("HOUR")
+	tss.Add(1526893200)
+
+	exp1 := []interface{}{
+		dt.NewEntity("example.org", "domain").
+			SetProperty("count", 1).SetProperty("time", tss),
+		dt.NewEntity("www.example.org", "hostname").
+			SetProperty("count", 1).SetProperty("time", tss),
+		dt.NewEntity("10.0.2.15", "ip").
+			SetProperty("count", 1).SetProperty("time", tss),
+		dt.NewEntity("8.8.8.8", "ip").
+			SetProperty("count", 1).SetProperty("time", tss),
+		dt.NewEdge("10.0.2.15", "www.example.org", "dnsquery").
+			SetProperty("count", 1).SetProperty("time", tss),
+		dt.NewEdge("www.example.org", "example.org", "indomain").
+			SetProperty("count", 1).SetProperty("time", tss),
+		dt.NewEdge("10.0.2.15", "8.8.8.8", "ipflow").
+			SetProperty("count", 1).SetProperty("time", tss).
+			SetProperty("protocol",
+				NewStringSet(map[string]bool{"udp": true})),
+	}
+
+	// Same payload every time.
+	for i := 0; i < 10; i++ {
+		RunChecks(t, e, exp1)
+	}
+
+}
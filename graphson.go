@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// GraphSON 3.0 typing of plain Go values, for JSON encoding.  Strings and
+// booleans are untyped, whole numbers are Int64, other numbers Double,
+// slices Lists and maps Maps.
+func ToGraphSON(v interface{}) interface{} {
+
+	typed := func(t string, v interface{}) interface{} {
+		return map[string]interface{}{"@type": t, "@value": v}
+	}
+
+	switch v := v.(type) {
+	case int:
+		return typed("g:Int64", int64(v))
+	case int64:
+		return typed("g:Int64", v)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return typed("g:Int64", int64(v))
+		}
+		return typed("g:Double", v)
+	case []int64:
+		l := make([]interface{}, len(v))
+		for i, x := range v {
+			l[i] = ToGraphSON(x)
+		}
+		return typed("g:List", l)
+	case []string:
+		l := make([]interface{}, len(v))
+		for i, x := range v {
+			l[i] = x
+		}
+		return typed("g:List", l)
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, x := range v {
+			l[i] = ToGraphSON(x)
+		}
+		return typed("g:List", l)
+	case map[string]interface{}:
+		// Maps are flat key, value lists.  Keys in order, so the same
+		// map always encodes the same way.
+		keys := make([]string, 0, len(v))
+		for k, _ := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		l := make([]interface{}, 0, 2*len(v))
+		for _, k := range keys {
+			l = append(l, k, ToGraphSON(v[k]))
+		}
+		return typed("g:Map", l)
+	case map[string]string:
+		m := map[string]interface{}{}
+		for k, x := range v {
+			m[k] = x
+		}
+		return ToGraphSON(m)
+	}
+
+	return v
+
+}
+
+// Plain value from decoded GraphSON 3.0.  Maps with non-string keys,
+// which Gremlin allows, have their keys dropped.
+func FromGraphSON(v interface{}) interface{} {
+
+	switch v := v.(type) {
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, x := range v {
+			l[i] = FromGraphSON(x)
+		}
+		return l
+	case map[string]interface{}:
+		t, ok := v["@type"].(string)
+		if !ok {
+			m := map[string]interface{}{}
+			for k, x := range v {
+				m[k] = FromGraphSON(x)
+			}
+			return m
+		}
+		val := v["@value"]
+		switch t {
+		case "g:List", "g:Set":
+			l, _ := val.([]interface{})
+			return FromGraphSON(l)
+		case "g:Map":
+			l, _ := val.([]interface{})
+			m := map[string]interface{}{}
+			for i := 0; i+1 < len(l); i += 2 {
+				if k, ok := FromGraphSON(l[i]).(string); ok {
+					m[k] = FromGraphSON(l[i+1])
+				}
+			}
+			return m
+		case "g:Int32", "g:Int64", "g:Float", "g:Double":
+			return val
+		}
+		return FromGraphSON(val)
+	}
+
+	return v
+
+}
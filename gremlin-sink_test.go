@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fake Gremlin Server, records eval bindings and answers with the status
+// code set.  Wants SASL authentication if user is set.
+type gremlinFake struct {
+	mu       sync.Mutex
+	user     string
+	code     int
+	conns    int
+	bindings []map[string]interface{}
+}
+
+func (f *gremlinFake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	f.mu.Lock()
+	f.conns++
+	f.mu.Unlock()
+
+	reply := func(id string, code int) {
+		j, _ := json.Marshal(map[string]interface{}{
+			"requestId": id,
+			"status":    map[string]interface{}{"code": code, "message": "fake"},
+			"result":    map[string]interface{}{"data": nil},
+		})
+		ws.WriteMessage(websocket.TextMessage, j)
+	}
+
+	authed := false
+	for {
+
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if len(msg) < 1 || string(msg[1:1+int(msg[0])]) != gremlinMimeType {
+			return
+		}
+
+		var req map[string]interface{}
+		json.Unmarshal(msg[1+int(msg[0]):], &req)
+		req = FromGraphSON(req).(map[string]interface{})
+		id := req["requestId"].(string)
+		args := req["args"].(map[string]interface{})
+
+		f.mu.Lock()
+		switch {
+
+		case req["op"] == "authentication":
+			sasl, _ := base64.StdEncoding.DecodeString(args["sasl"].(string))
+			if string(sasl) == "\x00"+f.user+"\x00secret" {
+				authed = true
+				// Results in two parts.
+				reply(id, 206)
+				reply(id, 200)
+			} else {
+				reply(id, 401)
+			}
+
+		case f.user != "" && !authed:
+			f.bindings = append(f.bindings,
+				args["bindings"].(map[string]interface{}))
+			reply(id, 407)
+
+		default:
+			if args["gremlin"] == gremlinUpsert {
+				f.bindings = append(f.bindings,
+					args["bindings"].(map[string]interface{}))
+			}
+			reply(id, f.code)
+
+		}
+		f.mu.Unlock()
+
+	}
+
+}
+
+func TestGremlinSink(t *testing.T) {
+
+	f := &gremlinFake{code: 200, user: "loader"}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	sink, err := OpenSink(strings.Replace(srv.URL, "http://",
+		"gremlin://loader:secret@", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	tm := time.Date(2018, 5, 21, 9, 19, 10, 0, time.UTC)
+	s := NewSummary()
+	for _, v := range []Summarisable{
+		&Node{"10.0.2.15", "ip"},
+		&Node{"10.0.2.15", "ip"},
+		&Edge{"10.0.2.15", "8.8.8.8", "ipflow"},
+		&EdgeProperty{Edge{"10.0.2.15", "8.8.8.8", "ipflow"},
+			"protocol", "udp"},
+	} {
+		v.Update(&s, tm)
+	}
+	elts, _ := s.toGraph(true)
+
+	// Twice, on one connection.
+	for i := 0; i < 2; i++ {
+		if err := sink.Write(elts); err != nil {
+			t.Fatalf("Write: %s", err.Error())
+		}
+	}
+	if f.conns != 1 {
+		t.Errorf("Expected one connection, got %d", f.conns)
+	}
+	if len(f.bindings) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(f.bindings))
+	}
+
+	b := f.bindings[0]
+	nodes := b["nodes"].([]interface{})
+	edges := b["edges"].([]interface{})
+	if len(nodes) != 1 || len(edges) != 1 {
+		t.Fatalf("Unexpected bindings %v", b)
+	}
+
+	node := nodes[0].(map[string]interface{})
+	p := node["p"].(map[string]interface{})
+	if node["id"] != "10.0.2.15" || node["group"] != "ip" ||
+		p["count"] != 2.0 || p["timeBucket"] != "HOUR" ||
+		len(p["time"].([]interface{})) != 1 {
+		t.Errorf("Unexpected node %v", node)
+	}
+
+	edge := edges[0].(map[string]interface{})
+	p = edge["p"].(map[string]interface{})
+	if edge["source"] != "10.0.2.15" || edge["destination"] != "8.8.8.8" ||
+		edge["group"] != "ipflow" || p["protocol"].([]interface{})[0] != "udp" {
+		t.Errorf("Unexpected edge %v", edge)
+	}
+
+	rules := b["rules"].(map[string]interface{})
+	for k, v := range map[string]string{
+		"count": "sum", "time": "union", "protocol": "union",
+		"timeBucket": "set",
+	} {
+		if rules[k] != v {
+			t.Errorf("Rule for %s: expected %s, got %v", k, v, rules[k])
+		}
+	}
+
+	// Script errors aren't worth retrying, server errors are.
+	f.code = 597
+	if err := sink.Write(elts); err == nil || Retryable(err) {
+		t.Errorf("Script error: %v", err)
+	}
+	f.code = 599
+	if err := sink.Write(elts); err == nil || !Retryable(err) {
+		t.Errorf("Server error: %v", err)
+	}
+
+	// Nothing listening.
+	srv.Close()
+	sink.Close()
+	if err := sink.Write(elts); err == nil || !Retryable(err) {
+		t.Errorf("Connection error: %v", err)
+	}
+
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLog distinct-count sketch, laid out to match the clearspring
+// HyperLogLogPlus used by Gaffer so that Gaffer can deserialise and merge
+// the sketches we send.  Only the normal (non-sparse) representation is
+// used: hashing is MurmurHash64A with clearspring's seed, registers are
+// 5 bits, packed 6 to a 32-bit word.
+
+const (
+	hllVersion  = 2
+	hllSeed     = 0xe17a1465
+	hllRegBits  = 5
+	hllPerWord  = 6
+	hllMaxValue = 1<<hllRegBits - 1
+
+	// Precision, 2^10 registers gives about 3% error.
+	SketchPrecision = 10
+)
+
+type HyperLogLog struct {
+	p    uint
+	regs []uint8
+}
+
+func NewHyperLogLog(p uint) *HyperLogLog {
+	return &HyperLogLog{p: p, regs: make([]uint8, 1<<p)}
+}
+
+// MurmurHash64A, as clearspring's MurmurHash.hash64.
+func murmur64(data []byte, seed uint32) uint64 {
+
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := uint64(seed) ^ (uint64(len(data)) * m)
+
+	n := len(data) / 8
+	for i := 0; i < n; i++ {
+		k := binary.LittleEndian.Uint64(data[i*8:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+
+	tail := data[n*8:]
+	if len(tail) > 0 {
+		for i := len(tail) - 1; i >= 0; i-- {
+			h ^= uint64(tail[i]) << (8 * uint(i))
+		}
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+
+	return h
+
+}
+
+// Add a value to the sketch.  Returns true if the sketch changed.
+func (this *HyperLogLog) Offer(s string) bool {
+
+	x := murmur64([]byte(s), hllSeed)
+
+	idx := x >> (64 - this.p)
+	w := x<<this.p | 1<<(this.p-1)
+	lz := uint8(bits.LeadingZeros64(w) + 1)
+	if lz > hllMaxValue {
+		lz = hllMaxValue
+	}
+
+	if lz > this.regs[idx] {
+		this.regs[idx] = lz
+		return true
+	}
+	return false
+
+}
+
+// Estimated number of distinct values offered.
+func (this *HyperLogLog) Cardinality() int64 {
+
+	m := float64(len(this.regs))
+
+	sum := 0.0
+	zeros := 0
+	for _, v := range this.regs {
+		sum += 1.0 / float64(uint64(1)<<v)
+		if v == 0 {
+			zeros++
+		}
+	}
+
+	est := 0.7213 / (1 + 1.079/m) * m * m / sum
+
+	// Linear counting for small cardinalities.
+	if est <= 2.5*m && zeros > 0 {
+		est = m * math.Log(m/float64(zeros))
+	}
+
+	return int64(est + 0.5)
+
+}
+
+// Java DataOutput unsigned varint, as clearspring's Varint.
+func writeVarint(b *bytes.Buffer, v uint32) {
+	for v&^0x7f != 0 {
+		b.WriteByte(byte(v&0x7f | 0x80))
+		v >>= 7
+	}
+	b.WriteByte(byte(v))
+}
+
+// Serialised form, as HyperLogLogPlus.getBytes.
+func (this *HyperLogLog) Bytes() []byte {
+
+	// Pack registers into words.
+	words := make([]uint32, (len(this.regs)+hllPerWord-1)/hllPerWord)
+	for i, v := range this.regs {
+		shift := hllRegBits * uint(i%hllPerWord)
+		words[i/hllPerWord] |= uint32(v) << shift
+	}
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, int32(-hllVersion))
+	writeVarint(&b, uint32(this.p))
+	writeVarint(&b, 0)                    // No sparse precision
+	writeVarint(&b, 0)                    // Normal format
+	writeVarint(&b, uint32(len(words)*4)) // Register bytes
+	binary.Write(&b, binary.BigEndian, words)
+
+	return b.Bytes()
+
+}
+
+// Gaffer JSON form of the sketch.
+func (this *HyperLogLog) Gaffer() map[string]interface{} {
+	return map[string]interface{}{
+		"com.clearspring.analytics.stream.cardinality.HyperLogLogPlus": map[string]interface{}{
+			"hyperLogLogPlus": map[string]interface{}{
+				"hyperLogLogPlusSketchBytes": base64.StdEncoding.EncodeToString(this.Bytes()),
+				"cardinality":                this.Cardinality(),
+			},
+		},
+	}
+}
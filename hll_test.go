@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+
+	h := NewHyperLogLog(SketchPrecision)
+
+	for _, n := range []int{10, 1000, 20000} {
+
+		h = NewHyperLogLog(SketchPrecision)
+		for i := 0; i < n; i++ {
+			// Offer everything twice, duplicates don't count.
+			h.Offer("host" + strconv.Itoa(i) + ".example.org")
+			h.Offer("host" + strconv.Itoa(i) + ".example.org")
+		}
+
+		c := h.Cardinality()
+		if c < int64(float64(n)*0.9) || c > int64(float64(n)*1.1) {
+			t.Errorf("%d distinct, estimated %d", n, c)
+		}
+
+	}
+
+	// Version, p, sp, format, register byte count, then registers.
+	b := h.Bytes()
+	head := []byte{0xff, 0xff, 0xff, 0xfe, 10, 0, 0, 0xac, 0x05}
+	if !bytes.HasPrefix(b, head) || len(b) != len(head)+171*4 {
+		t.Errorf("Unexpected serialisation: % x... (%d bytes)",
+			b[:len(head)], len(b))
+	}
+
+}
+
+func TestHyperLogLogGaffer(t *testing.T) {
+
+	h := NewHyperLogLog(SketchPrecision)
+	h.Offer("www.example.org")
+
+	// As Gaffer's HyperLogLogPlus JSON serialiser reads it.
+	j, err := json.Marshal(h.Gaffer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"com.clearspring.analytics.stream.cardinality.HyperLogLogPlus":` +
+		`{"hyperLogLogPlus":{"cardinality":1,"hyperLogLogPlusSketchBytes":"` +
+		base64.StdEncoding.EncodeToString(h.Bytes()) + `"}}}`
+	if string(j) != exp {
+		t.Errorf("Unexpected JSON: %s", j)
+	}
+
+	// Plain stores see the cardinality.
+	var v interface{}
+	json.Unmarshal(j, &v)
+	if c := PlainValue(v); c != 1.0 {
+		t.Errorf("Plain value %v", c)
+	}
+
+}
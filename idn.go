@@ -0,0 +1,119 @@
+package main
+
+import (
+	"golang.org/x/net/idna"
+	"net"
+	"strings"
+	"unicode"
+)
+
+// Hostname canonicalisation.  Names arrive from DNS, Host headers and
+// certificates in whatever form the client used, so "WWW.Example.ORG.",
+// "www.example.org" and punycode/Unicode forms of an IDN all have to end
+// up as the same vertex.  The canonical form is lower case, without a
+// trailing dot, with non-ASCII labels in punycode (xn--) form.
+
+// Canonical (ASCII) form of a hostname, by UTS #46 lookup mapping:
+// compatibility forms such as fullwidth letters and ideographic full stops
+// are mapped, Unicode normalised to NFC and converted to punycode.  Names
+// which don't validate, e.g. with _ in a label, are still mapped as far as
+// possible.
+func CanonicalName(s string) string {
+	a, _ := idna.Lookup.ToASCII(strings.TrimSpace(s))
+	return strings.TrimSuffix(a, ".")
+}
+
+// Host and port of an HTTP Host header value, without IPv6 brackets.
+// Port is empty if there isn't one.
+func splitHost(host string) (string, string) {
+	h, port, err := net.SplitHostPort(host)
+	if err != nil {
+		// No port.
+		h, port = host, ""
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(h, "["), "]"), port
+}
+
+// Canonical form of an HTTP Host header value.  Any port is kept, IP
+// address hosts are only normalised, IPv6 in brackets.
+func CanonicalHost(host string) string {
+
+	h, port := splitHost(host)
+
+	if ip := net.ParseIP(h); ip != nil {
+		h = ip.String()
+		if ip.To4() == nil {
+			h = "[" + h + "]"
+		}
+	} else {
+		h = CanonicalName(h)
+	}
+
+	if port != "" {
+		return h + ":" + port
+	}
+	return h
+
+}
+
+// Unicode display form of a hostname, punycode labels decoded.
+func DisplayName(s string) string {
+	u, _ := idna.Lookup.ToUnicode(strings.TrimSpace(s))
+	return strings.TrimSuffix(u, ".")
+}
+
+// Scripts considered when checking for homographs.  Characters in other
+// scripts, or common to all of them (digits, hyphen), aren't counted.
+var homographScripts = map[string]*unicode.RangeTable{
+	"Latin":    unicode.Latin,
+	"Cyrillic": unicode.Cyrillic,
+	"Greek":    unicode.Greek,
+	"Armenian": unicode.Armenian,
+	"Hebrew":   unicode.Hebrew,
+	"Arabic":   unicode.Arabic,
+	"Han":      unicode.Han,
+	"Hiragana": unicode.Hiragana,
+	"Katakana": unicode.Katakana,
+	"Hangul":   unicode.Hangul,
+	"Thai":     unicode.Thai,
+}
+
+// Japanese mixes these scripts legitimately.
+var japaneseScripts = map[string]bool{
+	"Han": true, "Hiragana": true, "Katakana": true, "Latin": true,
+}
+
+// True if any label of a hostname mixes scripts e.g. Latin with Cyrillic,
+// the usual trick for a lookalike name.
+func IsHomograph(s string) bool {
+
+	for _, label := range strings.Split(DisplayName(s), ".") {
+
+		scripts := map[string]bool{}
+		for _, r := range label {
+			for name, tbl := range homographScripts {
+				if unicode.Is(tbl, r) {
+					scripts[name] = true
+				}
+			}
+		}
+
+		if len(scripts) < 2 {
+			continue
+		}
+
+		japanese := true
+		for name := range scripts {
+			if !japaneseScripts[name] {
+				japanese = false
+			}
+		}
+		if !japanese {
+			return true
+		}
+
+	}
+
+	return false
+
+}
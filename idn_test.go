@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCanonicalName(t *testing.T) {
+
+	type test struct {
+		i string
+		c string
+		d string
+		h bool
+	}
+
+	tests := []test{
+		{i: "WWW.Example.ORG.", c: "www.example.org",
+			d: "www.example.org"},
+		{i: "www.Bücher.de", c: "www.xn--bcher-kva.de",
+			d: "www.bücher.de"},
+		{i: "www.xn--bcher-kva.de", c: "www.xn--bcher-kva.de",
+			d: "www.bücher.de"},
+		// Cyrillic а in paypal.
+		{i: "pаypal.com", c: "xn--pypal-4ve.com", d: "pаypal.com",
+			h: true},
+		// Wholly Cyrillic isn't mixed.
+		{i: "пример.рф", c: "xn--e1afmkfd.xn--p1ai", d: "пример.рф"},
+		// Japanese mixes scripts legitimately.
+		{i: "例え.テスト", c: "xn--r8jz45g.xn--zckzah", d: "例え.テスト"},
+		// Decomposed ü, as composed.
+		{i: "mu\u0308nchen.de", c: "xn--mnchen-3ya.de", d: "münchen.de"},
+		{i: "MÜNCHEN.de", c: "xn--mnchen-3ya.de", d: "münchen.de"},
+		// Ideographic full stops, fullwidth letters.
+		{i: "www\u3002example\u3002org\u3002", c: "www.example.org",
+			d: "www.example.org"},
+		{i: "ＷＷＷ.example.org", c: "www.example.org",
+			d: "www.example.org"},
+		// Not a valid hostname, but still mapped.
+		{i: "_dmarc.Bücher.de", c: "_dmarc.xn--bcher-kva.de",
+			d: "_dmarc.bücher.de"},
+		// Root.
+		{i: ".", c: "", d: ""},
+	}
+
+	for _, v := range tests {
+
+		if c := CanonicalName(v.i); c != v.c {
+			t.Errorf("canonical %s -> %s (%s)", v.i, v.c, c)
+		}
+		if d := DisplayName(v.i); d != v.d {
+			t.Errorf("display %s -> %s (%s)", v.i, v.d, d)
+		}
+		if h := IsHomograph(v.i); h != v.h {
+			t.Errorf("homograph %s -> %v (%v)", v.i, v.h, h)
+		}
+
+	}
+
+	hosts := map[string]string{
+		"WWW.Example.ORG:8080":   "www.example.org:8080",
+		"www.Bücher.de":          "www.xn--bcher-kva.de",
+		"10.0.2.15:8080":         "10.0.2.15:8080",
+		"[2001:DB8::1]:80":       "[2001:db8::1]:80",
+		"[2001:DB8:0:0:0:0:0:1]": "[2001:db8::1]",
+		"2001:db8::1":            "[2001:db8::1]",
+	}
+	for k, v := range hosts {
+		if h := CanonicalHost(k); h != v {
+			t.Errorf("canonical host %s -> %s (%s)", k, v, h)
+		}
+	}
+
+}
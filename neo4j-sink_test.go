@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Stand-in for the Neo4j transactional endpoint, records statements and
+// answers with the error code set.
+type neo4jStandIn struct {
+	mu    sync.Mutex
+	stmts []cypherStatement
+	code  string
+}
+
+func (n *neo4jStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	user, pass, _ := r.BasicAuth()
+	if r.Method != "POST" || r.URL.Path != "/db/threats/tx/commit" ||
+		user != "neo4j" || pass != "secret" {
+		w.WriteHeader(404)
+		return
+	}
+
+	var req struct {
+		Statements []cypherStatement `json:"statements"`
+	}
+	b, _ := ioutil.ReadAll(r.Body)
+	json.Unmarshal(b, &req)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.stmts = append(n.stmts, req.Statements...)
+
+	errs := []interface{}{}
+	if n.code != "" {
+		errs = append(errs, map[string]string{
+			"code": n.code, "message": "failed",
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": []interface{}{}, "errors": errs,
+	})
+
+}
+
+func TestNeo4jSink(t *testing.T) {
+
+	si := &neo4jStandIn{}
+	srv := httptest.NewServer(si)
+	defer srv.Close()
+
+	sink, err := OpenSink(strings.Replace(srv.URL, "http://",
+		"neo4j://neo4j:secret@", 1) + "/threats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	tm := time.Date(2018, 5, 21, 9, 19, 10, 0, time.UTC)
+	s := NewSummary()
+	for _, v := range []Summarisable{
+		&Node{"10.0.2.15", "ip"},
+		&Node{"8.8.8.8", "ip"},
+		&Edge{"10.0.2.15", "8.8.8.8", "ipflow"},
+		&EdgeProperty{Edge{"10.0.2.15", "8.8.8.8", "ipflow"},
+			"protocol", "udp"},
+	} {
+		v.Update(&s, tm)
+	}
+	elts, _ := s.toGraph(true)
+
+	err = sink.Write(elts)
+	if err != nil {
+		t.Fatalf("Write: %s", err.Error())
+	}
+
+	// Constraint, then a statement for the ip group and one for ipflow.
+	if len(si.stmts) != 3 ||
+		!strings.HasPrefix(si.stmts[0].Statement, "CREATE CONSTRAINT") {
+		t.Fatalf("Unexpected statements %v", si.stmts)
+	}
+
+	node := si.stmts[1].Statement
+	for _, v := range []string{
+		"MERGE (n:Vertex {id: row.id})",
+		"SET n:`ip`",
+		"n.`ip_count` = coalesce(n.`ip_count`, 0) + coalesce(row.p.`count`, 0)",
+		"n.`ip_time` = reduce(acc = coalesce(n.`ip_time`, []), v IN coalesce(row.p.`time`, [])",
+	} {
+		if !strings.Contains(node, v) {
+			t.Errorf("Node statement lacks %s: %s", v, node)
+		}
+	}
+	rows := si.stmts[1].Parameters["rows"].([]interface{})
+	if len(rows) != 2 {
+		t.Errorf("Expected 2 node rows, got %d", len(rows))
+	}
+
+	edge := si.stmts[2].Statement
+	for _, v := range []string{
+		"MERGE (s)-[r:`ipflow`]->(d)",
+		"r.`count` = coalesce(r.`count`, 0) + coalesce(row.p.`count`, 0)",
+		"r.`protocol` = reduce(",
+	} {
+		if !strings.Contains(edge, v) {
+			t.Errorf("Edge statement lacks %s: %s", v, edge)
+		}
+	}
+	row := si.stmts[2].Parameters["rows"].([]interface{})[0].(map[string]interface{})
+	p := row["p"].(map[string]interface{})
+	if row["source"] != "10.0.2.15" || p["count"] != 1.0 ||
+		p["timeBucket"] != "HOUR" || len(p["time"].([]interface{})) != 1 {
+		t.Errorf("Unexpected edge row %v", row)
+	}
+
+	// Errors, transient ones are worth retrying.
+	si.code = "Neo.TransientError.Transaction.DeadlockDetected"
+	if err := sink.Write(elts); err == nil || !Retryable(err) {
+		t.Errorf("Transient error: %v", err)
+	}
+	si.code = "Neo.ClientError.Statement.SyntaxError"
+	if err := sink.Write(elts); err == nil || Retryable(err) {
+		t.Errorf("Client error: %v", err)
+	}
+
+}
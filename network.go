@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net"
+)
+
+// Private address ranges.  The same ranges are reused on every customer
+// network, so 10.0.2.15 on one network isn't 10.0.2.15 on another.
+var privateNets = mustParseCIDRs(
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", // RFC1918
+	"100.64.0.0/10",  // Carrier-grade NAT
+	"169.254.0.0/16", // Link local
+	"127.0.0.0/8",
+	"fc00::/7", // Unique local
+	"fe80::/10",
+	"::1/128",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, v := range cidrs {
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func IsPrivate(ip net.IP) bool {
+	for _, v := range privateNets {
+		if v.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Vertex name for an IP address seen on a network.  Addresses are put in
+// canonical form.  With Options.ScopePrivate, private addresses are
+// qualified by the network, zone-style e.g. 10.0.2.15%customer-a.
+func IPVertex(addr, network string) string {
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr
+	}
+	addr = ip.String()
+
+	if Options.ScopePrivate && network != "" && IsPrivate(ip) {
+		return addr + "%" + network
+	}
+
+	return addr
+
+}
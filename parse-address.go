@@ -2,20 +2,80 @@
 package main
 
 import (
+	"fmt"
+	"net"
+	"strconv"
 	"strings"
 )
 
-// Convert a list of address (as we use in event src/dest to
-// (address, port, protocol) tuple.
-func ParseAddress(a []string) (string, string, string) {
+// An address from an event src/dest list.
+type Address struct {
+	IP    net.IP
+	Port  int
+	Proto string // L4 protocol: tcp, udp or icmp
+	App   string // Application protocol e.g. http, dns
+}
+
+// IP in canonical form, empty if there's no IP.
+func (a Address) Addr() string {
+	if a.IP == nil {
+		return ""
+	}
+	return a.IP.String()
+}
+
+// Decimal number no greater than max, digits only, so no sign.
+func parseDecimal(s string, max int) (int, bool) {
+	if s == "" || len(s) > len(strconv.Itoa(max)) {
+		return 0, false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n > max {
+		return 0, false
+	}
+	return n, true
+}
+
+// Parse dotted-quad, tolerating zero-padded octets so that e.g.
+// 010.000.002.015 is the same address as 10.0.2.15.
+func parseIPv4(s string) net.IP {
+
+	parts := strings.Split(s, ".")
+	if len(parts) != 4 {
+		return nil
+	}
 
-	var ip, port, proto string
+	b := make([]byte, 4)
+	for i, v := range parts {
+		n, ok := parseDecimal(v, 255)
+		if !ok {
+			return nil
+		}
+		b[i] = byte(n)
+	}
+
+	return net.IPv4(b[0], b[1], b[2], b[3])
+
+}
+
+// Convert a list of addresses (as we use in event src/dest) to an Address.
+// Lists are ordered outermost protocol first, so for tunnelled traffic
+// the innermost addresses are the ones returned.  A new IP layer resets
+// the port and protocols.
+func ParseAddress(a []string) (Address, error) {
+
+	var res Address
 
         // Loop through source addresses
         for _, v := range a {
 
                 var cls, addr string
-		
+
                 // Split into address class and value parts (if present)
                 val_parts := strings.SplitN(v, ":", 2)
 
@@ -29,23 +89,39 @@ func ParseAddress(a []string) (string, string, string) {
                 // Store in appropriate address values.
                 switch {
                 case cls == "ipv6":
-                        ip = addr
+			ip := net.ParseIP(addr)
+			if ip == nil || !strings.Contains(addr, ":") {
+				return Address{}, fmt.Errorf("bad ipv6 address: %s",
+					addr)
+			}
+			res = Address{IP: ip}
                 case cls == "ipv4":
-                        ip = addr
-                case cls == "tcp":
-                        port = addr
-                        proto = "tcp"
-                case cls == "udp":
-                        port = addr
-                        proto = "udp"
+			ip := parseIPv4(addr)
+			if ip == nil {
+				return Address{}, fmt.Errorf("bad ipv4 address: %s",
+					addr)
+			}
+			res = Address{IP: ip}
+                case cls == "tcp" || cls == "udp":
+			port, ok := parseDecimal(addr, 65535)
+			if !ok {
+				return Address{}, fmt.Errorf("bad %s port: %s",
+					cls, addr)
+			}
+                        res.Port = port
+                        res.Proto = cls
+			res.App = ""
                 case cls == "icmp":
-                        port = ""
-                        proto = "icmp"
+                        res.Port = 0
+                        res.Proto = "icmp"
+			res.App = ""
+		case addr == "" && cls != "":
+			res.App = cls
                 }
 
         }
 
-	return ip, port, proto
+	return res, nil
 
 }
 
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+
+	type test struct {
+		i     []string
+		addr  string
+		port  int
+		proto string
+		app   string
+	}
+
+	tests := []test{
+		{i: []string{"ipv4:10.0.2.15", "tcp:34060", "http"},
+			addr: "10.0.2.15", port: 34060, proto: "tcp", app: "http"},
+		{i: []string{"ipv4:010.000.002.015", "udp:53", "dns"},
+			addr: "10.0.2.15", port: 53, proto: "udp", app: "dns"},
+		{i: []string{"ipv6:2606:2800:0220:0001:0248:1893:25C8:1946",
+			"tcp:443"},
+			addr: "2606:2800:220:1:248:1893:25c8:1946", port: 443,
+			proto: "tcp"},
+		{i: []string{"ipv4:10.0.2.15", "icmp"},
+			addr: "10.0.2.15", proto: "icmp"},
+
+		// Tunnelled: outer VXLAN, inner HTTP, inner wins.
+		{i: []string{"ipv4:192.168.1.1", "udp:4789", "vxlan",
+			"ipv4:10.0.2.15", "tcp:80", "http"},
+			addr: "10.0.2.15", port: 80, proto: "tcp", app: "http"},
+
+		// Inner IP with no port doesn't inherit the outer one.
+		{i: []string{"ipv4:192.168.1.1", "udp:4789",
+			"ipv4:10.0.2.15"},
+			addr: "10.0.2.15"},
+
+		{i: []string{"tcp:80"}, port: 80, proto: "tcp"},
+		{i: []string{}},
+	}
+
+	for _, v := range tests {
+
+		a, err := ParseAddress(v.i)
+		if err != nil {
+			t.Errorf("%v: %s", v.i, err.Error())
+			continue
+		}
+
+		if a.Addr() != v.addr || a.Port != v.port ||
+			a.Proto != v.proto || a.App != v.app {
+			t.Errorf("%v -> %s %d %s %s (%s %d %s %s)", v.i,
+				v.addr, v.port, v.proto, v.app,
+				a.Addr(), a.Port, a.Proto, a.App)
+		}
+
+	}
+
+	bad := [][]string{
+		{"ipv4:10.0.2"},
+		{"ipv4:10.0.2.256"},
+		{"ipv4:2606:2800::1"},
+		{"ipv6:10.0.2.15"},
+		{"ipv6:2606:2800::g"},
+		{"ipv4:10.0.2.15", "tcp:http"},
+		{"ipv4:10.0.2.15", "udp:65536"},
+		{"ipv4:+1.2.3.4"},
+		{"ipv4:1.2.-0.4"},
+		{"ipv4:10.0.2.15", "tcp:+80"},
+		{"ipv4:10.0.2.15", "tcp:-0"},
+		{"ipv4:10.0.2.15", "tcp: 80"},
+		{"ipv4:10.0.2.15", "tcp:"},
+	}
+
+	for _, v := range bad {
+		if _, err := ParseAddress(v); err == nil {
+			t.Errorf("%v: accepted", v)
+		}
+	}
+
+}
@@ -0,0 +1,133 @@
+package main
+
+//go:generate go run gen-public-suffix.go
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Public Suffix List rule kinds, a suffix may have more than one.
+const (
+	pslNormal = 1 << iota
+	pslWildcard
+	pslException
+)
+
+// A parsed Public Suffix List.  Rules are keyed on the suffix with any
+// leading "*." or "!" removed, e.g. "*.ck" is held as "ck" => wildcard.
+type SuffixList struct {
+	rules map[string]int
+}
+
+// Parse a list in public_suffix_list.dat format.  Rules from the private
+// section (e.g. blogspot.com) are only included if private is set.
+func ParseSuffixList(r io.Reader, private bool) (*SuffixList, error) {
+
+	l := &SuffixList{rules: map[string]int{}}
+	inPrivate := false
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+
+		line := strings.TrimSpace(sc.Text())
+
+		if strings.HasPrefix(line, "//") {
+			if strings.Contains(line, "===BEGIN PRIVATE DOMAINS===") {
+				inPrivate = true
+			}
+			if strings.Contains(line, "===END PRIVATE DOMAINS===") {
+				inPrivate = false
+			}
+			continue
+		}
+
+		// Rule is everything up to the first whitespace.
+		fields := strings.Fields(line)
+		if len(fields) == 0 || (inPrivate && !private) {
+			continue
+		}
+		// IDN rules are held in punycode form, as names are.
+		rule := CanonicalName(fields[0])
+
+		switch {
+		case strings.HasPrefix(rule, "!"):
+			l.rules[rule[1:]] |= pslException
+		case strings.HasPrefix(rule, "*."):
+			l.rules[rule[2:]] |= pslWildcard
+		default:
+			l.rules[rule] |= pslNormal
+		}
+
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+
+}
+
+// Number of labels at the end of labels which make up the public suffix.
+// The longest matching rule wins, unless an exception rule matches.
+func (l *SuffixList) suffixLabels(labels []string) int {
+
+	// Default rule is "*", a single label.
+	best := 1
+
+	for i := range labels {
+
+		n := len(labels) - i
+		kind := l.rules[strings.Join(labels[i:], ".")]
+
+		if kind&pslException != 0 {
+			return n - 1
+		}
+
+		if kind&pslNormal != 0 && n > best {
+			best = n
+		}
+
+		// A wildcard on the parent covers this label.
+		if n > best && i+1 < len(labels) &&
+			l.rules[strings.Join(labels[i+1:], ".")]&pslWildcard != 0 {
+			best = n
+		}
+
+	}
+
+	return best
+
+}
+
+// Public suffix of a hostname e.g. www.example.co.uk -> co.uk.
+func (l *SuffixList) PublicSuffix(s string) string {
+	labels := strings.Split(strings.ToLower(s), ".")
+	n := l.suffixLabels(labels)
+	return strings.Join(labels[len(labels)-n:], ".")
+}
+
+// Registrable domain of a hostname, the public suffix plus one label e.g.
+// www.example.co.uk -> example.co.uk.  Empty if the name is itself a
+// public suffix, or has an empty label.
+func (l *SuffixList) Domain(s string) string {
+
+	labels := strings.Split(s, ".")
+	for _, v := range labels {
+		if v == "" {
+			return ""
+		}
+	}
+
+	lower := strings.Split(strings.ToLower(s), ".")
+
+	n := l.suffixLabels(lower)
+	if n >= len(labels) {
+		return ""
+	}
+
+	return strings.Join(labels[len(labels)-n-1:], ".")
+
+}
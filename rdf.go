@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	rdfNS = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xsdNS = "http://www.w3.org/2001/XMLSchema#"
+
+	// Default vocabulary.
+	DefaultRdfBase = "http://trustnetworks.com/threat-graph/"
+)
+
+// RDF serialisation of graph elements, as N-Triples or Turtle.  Vertices
+// are IRIs under <base>vertex/, groups and properties are terms under
+// base.  A node is an rdf:type triple from its vertex to its group, an
+// edge a triple from source to destination with its group as predicate.
+//
+// Counts, time buckets and other properties are per summary, so each
+// element triple gets a new observation resource holding them, and
+// totals come from aggregating observations, e.g. SUM(?count).  With Star
+// the observation annotates the triple, RDF-star style:
+//
+//   << <v> rdf:type tg:ip >> tg:observation _:o1 .
+//   _:o1 tg:count "2"^^xsd:long .
+//
+// otherwise the observation is a reified rdf:Statement.
+type RdfWriter struct {
+	Base   string
+	Turtle bool
+	Star   bool
+
+	// Blank node labels, unique across writers and batches.
+	prefix string
+	seq    uint64
+}
+
+func NewRdfWriter(base string, turtle, star bool) *RdfWriter {
+	if base == "" {
+		base = DefaultRdfBase
+	}
+	b := make([]byte, 4)
+	rand.Read(b)
+	return &RdfWriter{
+		Base:   base,
+		Turtle: turtle,
+		Star:   star,
+		prefix: fmt.Sprintf("o%x", b),
+	}
+}
+
+// Names which can be Turtle prefixed names.
+var rdfLocalName = regexp.MustCompile("^[A-Za-z][A-Za-z0-9_-]*$")
+
+// Turtle prefix declarations.
+func (w *RdfWriter) Header() []byte {
+	if !w.Turtle {
+		return nil
+	}
+	return []byte("@prefix tg: <" + w.Base + "> .\n" +
+		"@prefix rdf: <" + rdfNS + "> .\n" +
+		"@prefix xsd: <" + xsdNS + "> .\n\n")
+}
+
+// Term for an IRI in base, ns or elsewhere.
+func (w *RdfWriter) iri(s string) string {
+	if w.Turtle {
+		for p, ns := range map[string]string{
+			"tg:": w.Base, "rdf:": rdfNS, "xsd:": xsdNS,
+		} {
+			if strings.HasPrefix(s, ns) &&
+				rdfLocalName.MatchString(s[len(ns):]) {
+				return p + s[len(ns):]
+			}
+		}
+	}
+	return "<" + escapeIRI(s) + ">"
+}
+
+// Percent-encode characters which can't appear in an IRI.
+func escapeIRI(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if c <= 0x20 || strings.IndexByte("<>\"{}|^`\\", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func (w *RdfWriter) vertex(v string) string {
+	return w.iri(w.Base + "vertex/" + url.PathEscape(v))
+}
+
+func (w *RdfWriter) term(name string) string {
+	return w.iri(w.Base + name)
+}
+
+// Quoted string literal.
+func rdfString(s string) string {
+	r := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n",
+		"\r", "\\r")
+	return "\"" + r.Replace(s) + "\""
+}
+
+func (w *RdfWriter) typed(lex, dt string) string {
+	return rdfString(lex) + "^^" + w.iri(xsdNS+dt)
+}
+
+func (w *RdfWriter) dateTime(tm time.Time) string {
+	return w.typed(tm.UTC().Format(time.RFC3339Nano), "dateTime")
+}
+
+// Literals for a property value, a list gives one per member.
+func (w *RdfWriter) literals(name string, v interface{}) []string {
+
+	switch v := v.(type) {
+	case []int64:
+		res := []string{}
+		for _, t := range v {
+			res = append(res, w.dateTime(time.Unix(t, 0)))
+		}
+		return res
+	case []interface{}:
+		res := []string{}
+		for _, x := range v {
+			res = append(res, w.literals(name, x)...)
+		}
+		return res
+	case string:
+		return []string{rdfString(v)}
+	case bool:
+		return []string{w.typed(strconv.FormatBool(v), "boolean")}
+	case float64:
+		if name == "firstSeen" || name == "lastSeen" {
+			// Milliseconds since the epoch.
+			ms := int64(v)
+			return []string{w.dateTime(time.Unix(ms/1000,
+				(ms%1000)*int64(time.Millisecond)))}
+		}
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return []string{w.typed(strconv.FormatInt(int64(v), 10),
+				"long")}
+		}
+		return []string{w.typed(strconv.FormatFloat(v, 'g', -1, 64),
+			"double")}
+	}
+
+	return nil
+
+}
+
+// Write elements as RDF.
+func (w *RdfWriter) Encode(elements []GafferElement) []byte {
+
+	var b bytes.Buffer
+
+	for _, e := range elements {
+
+		var s, p, o string
+		if e.IsEdge() {
+			s, p, o = w.vertex(e.Source), w.term(e.Group),
+				w.vertex(e.Destination)
+		} else {
+			s, p, o = w.vertex(e.Vertex), w.iri(rdfNS+"type"),
+				w.term(e.Group)
+		}
+
+		triple := s + " " + p + " " + o
+		b.WriteString(triple + " .\n")
+
+		if !e.IsEdge() {
+			b.WriteString(s + " " + w.term("name") + " " +
+				rdfString(e.Vertex) + " .\n")
+		}
+
+		// Observation of this summary.
+		obs := fmt.Sprintf("_:%s_%d", w.prefix,
+			atomic.AddUint64(&w.seq, 1))
+		if w.Star {
+			b.WriteString("<< " + triple + " >> " +
+				w.term("observation") + " " + obs + " .\n")
+		} else {
+			b.WriteString(obs + " " + w.iri(rdfNS+"type") + " " +
+				w.iri(rdfNS+"Statement") + " .\n")
+			b.WriteString(obs + " " + w.iri(rdfNS+"subject") + " " + s +
+				" .\n")
+			b.WriteString(obs + " " + w.iri(rdfNS+"predicate") + " " + p +
+				" .\n")
+			b.WriteString(obs + " " + w.iri(rdfNS+"object") + " " + o +
+				" .\n")
+		}
+
+		props := PlainProperties(e.Properties)
+		keys := make([]string, 0, len(props))
+		for k, _ := range props {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, l := range w.literals(k, props[k]) {
+				b.WriteString(obs + " " + w.term(k) + " " + l + " .\n")
+			}
+		}
+
+	}
+
+	return b.Bytes()
+
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// Reverse DNS names.  A PTR lookup for 4.3.2.1.in-addr.arpa is a question
+// about the address 1.2.3.4, not a hostname, so these names are decoded
+// back to the IP address rather than put in the graph as hostnames.
+
+const (
+	inAddrArpa = ".in-addr.arpa"
+	ip6Arpa    = ".ip6.arpa"
+)
+
+// True for names in the reverse DNS trees, whether or not they decode to
+// a complete address.  The name should be in canonical form.
+func IsReverseName(name string) bool {
+	return strings.HasSuffix(name, inAddrArpa) ||
+		strings.HasSuffix(name, ip6Arpa) ||
+		name == inAddrArpa[1:] || name == ip6Arpa[1:]
+}
+
+// Address a reverse DNS name refers to e.g. 4.3.2.1.in-addr.arpa ->
+// 1.2.3.4.  Empty if the name isn't a complete reverse name.
+func ReverseAddress(name string) string {
+
+	switch {
+
+	case strings.HasSuffix(name, inAddrArpa):
+
+		labels := strings.Split(strings.TrimSuffix(name, inAddrArpa),
+			".")
+		if len(labels) != 4 {
+			return ""
+		}
+		for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+			labels[i], labels[j] = labels[j], labels[i]
+		}
+		ip := net.ParseIP(strings.Join(labels, "."))
+		if ip == nil || ip.To4() == nil {
+			return ""
+		}
+		return ip.String()
+
+	case strings.HasSuffix(name, ip6Arpa):
+
+		nibbles := strings.Split(strings.TrimSuffix(name, ip6Arpa), ".")
+		if len(nibbles) != 32 {
+			return ""
+		}
+		hex := make([]byte, 0, 39)
+		for i := len(nibbles) - 1; i >= 0; i-- {
+			if len(nibbles[i]) != 1 {
+				return ""
+			}
+			hex = append(hex, nibbles[i][0])
+			if i%4 == 0 && i > 0 {
+				hex = append(hex, ':')
+			}
+		}
+		ip := net.ParseIP(string(hex))
+		if ip == nil {
+			return ""
+		}
+		return ip.String()
+
+	}
+
+	return ""
+
+}
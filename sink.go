@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// A graph store which takes the elements produced by Summary.ToGraph.
+// Write may be called from several goroutines at once.  Errors which are
+// worth retrying are reported as such by Retryable.
+type GraphSink interface {
+	Write(elements []interface{}) error
+	Close() error
+}
+
+// Sink constructors by URL scheme.
+var sinkSchemes = map[string]func(*url.URL) (GraphSink, error){}
+
+// Make a sink available under a URL scheme.
+func RegisterSink(scheme string, open func(*url.URL) (GraphSink, error)) {
+	sinkSchemes[scheme] = open
+}
+
+// Open the sink for a URL e.g. gaffer://gaffer-threat:8080/rest/v1,
+// selected by its scheme.
+func OpenSink(s string) (GraphSink, error) {
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	open, ok := sinkSchemes[strings.ToLower(u.Scheme)]
+	if !ok {
+		schemes := []string{}
+		for k, _ := range sinkSchemes {
+			schemes = append(schemes, k)
+		}
+		sort.Strings(schemes)
+		return nil, fmt.Errorf("unknown sink scheme '%s', expected one of %s",
+			u.Scheme, strings.Join(schemes, ", "))
+	}
+
+	return open(u)
+
+}
+
+// A Gaffer element as it appears in JSON, for sinks which need to pick
+// elements apart.
+type GafferElement struct {
+	Class       string                 `json:"class"`
+	Group       string                 `json:"group"`
+	Vertex      string                 `json:"vertex,omitempty"`
+	Source      string                 `json:"source,omitempty"`
+	Destination string                 `json:"destination,omitempty"`
+	Directed    bool                   `json:"directed,omitempty"`
+	Properties  map[string]interface{} `json:"properties"`
+}
+
+func (e *GafferElement) IsEdge() bool {
+	return strings.HasSuffix(e.Class, ".Edge")
+}
+
+// Decode elements from ToGraph, by way of their JSON form, so that sinks
+// see the same thing whatever Go types produced them.
+func DecodeElements(elements []interface{}) ([]GafferElement, error) {
+
+	j, err := json.Marshal(elements)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []GafferElement
+	err = json.Unmarshal(j, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+
+}
+
+// Time bucket and bucket start times from a Gaffer TimestampSet property.
+func TimeSet(v interface{}) (string, []int64, bool) {
+
+	inner, ok := typedValue(v)
+	if !ok {
+		return "", nil, false
+	}
+	m, ok := inner.(map[string]interface{})
+	if !ok {
+		return "", nil, false
+	}
+	list, ok := m["timestamps"].([]interface{})
+	if !ok {
+		return "", nil, false
+	}
+
+	bucket, _ := m["timeBucket"].(string)
+	ts := make([]int64, 0, len(list))
+	for _, t := range list {
+		if f, ok := t.(float64); ok {
+			ts = append(ts, int64(f))
+		}
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i] < ts[j] })
+
+	return bucket, ts, true
+
+}
+
+// Inner value of a Gaffer typed JSON value, {"java.util.TreeSet":[...]}.
+func typedValue(v interface{}) (interface{}, bool) {
+	m, ok := v.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return nil, false
+	}
+	for k, inner := range m {
+		if strings.Contains(k, ".") {
+			return inner, true
+		}
+	}
+	return nil, false
+}
+
+// Cardinality of the inner value of a HyperLogLogPlus sketch,
+// {"hyperLogLogPlus":{"hyperLogLogPlusSketchBytes":..,"cardinality":..}}.
+func sketchCardinality(v interface{}) (interface{}, bool) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	hll, ok := m["hyperLogLogPlus"].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	c, ok := hll["cardinality"]
+	return c, ok
+}
+
+// Property value without Gaffer typing, for stores with plain types.
+// String sets become lists, TimestampSets lists of times and sketches
+// their cardinality.
+func PlainValue(v interface{}) interface{} {
+
+	if _, ts, ok := TimeSet(v); ok {
+		return ts
+	}
+
+	inner, ok := typedValue(v)
+	if !ok {
+		return v
+	}
+	if c, ok := sketchCardinality(inner); ok {
+		return c
+	}
+	return inner
+
+}
+
+// Element properties as plain values.  A TimestampSet property becomes a
+// list of times, with its bucket in a property of the same name with a
+// Bucket suffix, e.g. time and timeBucket.
+func PlainProperties(props map[string]interface{}) map[string]interface{} {
+	p := map[string]interface{}{}
+	for k, v := range props {
+		if bucket, ts, ok := TimeSet(v); ok {
+			p[k] = ts
+			p[k+"Bucket"] = bucket
+		} else {
+			p[k] = PlainValue(v)
+		}
+	}
+	return p
+}
+
+// Element properties for stores which merge elements themselves.  Sketch
+// properties are left out, as their cardinality is only for one flush
+// and can't be merged with a stored count.
+func MergeableProperties(props map[string]interface{}) map[string]interface{} {
+	p := PlainProperties(props)
+	for k, v := range props {
+		if inner, ok := typedValue(v); ok {
+			if _, ok := sketchCardinality(inner); ok {
+				delete(p, k)
+			}
+		}
+	}
+	return p
+}
+
+// How a property combines with the value already stored, for stores
+// which merge elements themselves.  Counts and volumes add up (sum),
+// first and min values take the minimum (min), last and max values the
+// maximum (max), sets such as time buckets are unioned (union) and
+// anything else is replaced (set).
+func MergeRule(name string, v interface{}) string {
+	switch {
+	case name == "count" || name == "bytes" || name == "packets" ||
+		name == "duration":
+		return "sum"
+	case name == "firstSeen" || strings.HasPrefix(name, "min"):
+		return "min"
+	case name == "lastSeen" || strings.HasPrefix(name, "max"):
+		return "max"
+	}
+	if _, ok := v.([]int64); ok {
+		return "union"
+	}
+	if _, ok := v.([]interface{}); ok {
+		return "union"
+	}
+	return "set"
+}
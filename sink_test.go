@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestOpenSink(t *testing.T) {
+
+	tests := []struct {
+		url string
+		exp string
+	}{
+		{"http://gaffer-threat:8080/rest/v1", "http://gaffer-threat:8080/rest/v1"},
+		{"gaffer://gaffer-threat:8080/rest/v1/", "http://gaffer-threat:8080/rest/v1"},
+		{"gaffers://gaffer:8443/rest/v1", "https://gaffer:8443/rest/v1"},
+	}
+
+	for _, v := range tests {
+		s, err := OpenSink(v.url)
+		if err != nil {
+			t.Errorf("%s: %s", v.url, err.Error())
+			continue
+		}
+		g, ok := s.(*GafferSink)
+		if !ok || g.url != v.exp {
+			t.Errorf("%s: expected Gaffer sink at %s, got %#v", v.url,
+				v.exp, s)
+		}
+		s.Close()
+	}
+
+	if _, err := OpenSink("nosuch://host/"); err == nil {
+		t.Errorf("Unknown scheme accepted")
+	}
+
+}
+
+func TestGafferUnencodable(t *testing.T) {
+
+	// Never sent, so never retried.
+	s := NewGafferSink("http://gaffer-threat:8080/rest/v1")
+	err := s.Write([]interface{}{math.Inf(1)})
+	if err == nil || Retryable(err) {
+		t.Errorf("Expected a permanent error, got %v", err)
+	}
+
+}
+
+func TestMergeableProperties(t *testing.T) {
+
+	h := NewHyperLogLog(SketchPrecision)
+	h.Offer("www.example.org")
+	j, _ := json.Marshal(map[string]interface{}{
+		"count":                2,
+		"dnsqueryDestinations": h.Gaffer(),
+	})
+	var props map[string]interface{}
+	json.Unmarshal(j, &props)
+
+	// Sketches can't be merged, so they're left out.
+	p := MergeableProperties(props)
+	if len(p) != 1 || p["count"] != 2.0 {
+		t.Errorf("Unexpected properties %v", p)
+	}
+
+}
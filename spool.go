@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrSpoolFull = errors.New("spool full")
+
+// Directory of AddElements bodies which couldn't be sent, kept for
+// replay.  Each body is a file, named so that names sort oldest first.
+// Files are written under a temporary name, synced and renamed, so a
+// crash shouldn't leave a partial body behind.  Any which can't be
+// decoded are dropped on replay.
+type Spool struct {
+	dir      string
+	maxBytes int64
+	maxFiles int
+
+	mu    sync.Mutex
+	files []string
+	sizes map[string]int64
+	size  int64
+	seq   int
+}
+
+// Open a spool directory, creating it if needed and picking up bodies
+// left by a previous run.  Zero limits are ignored.
+func NewSpool(dir string, maxBytes int64, maxFiles int) (*Spool, error) {
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &Spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxFiles: maxFiles,
+		sizes:    map[string]int64{},
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range fis {
+		switch {
+		case strings.HasSuffix(v.Name(), ".tmp"):
+			// Incomplete write.
+			os.Remove(filepath.Join(dir, v.Name()))
+		case strings.HasSuffix(v.Name(), ".json"):
+			sp.files = append(sp.files, v.Name())
+			sp.sizes[v.Name()] = v.Size()
+			sp.size += v.Size()
+		}
+	}
+	sort.Strings(sp.files)
+
+	return sp, nil
+
+}
+
+// Number of bodies and bytes held.
+func (sp *Spool) Len() (int, int64) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return len(sp.files), sp.size
+}
+
+// Store a body, ErrSpoolFull if it would go over a limit.
+func (sp *Spool) Put(body []byte) error {
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.maxBytes > 0 && sp.size+int64(len(body)) > sp.maxBytes {
+		return ErrSpoolFull
+	}
+	if sp.maxFiles > 0 && len(sp.files) >= sp.maxFiles {
+		return ErrSpoolFull
+	}
+
+	sp.seq++
+	name := fmt.Sprintf("%020d-%06d.json", time.Now().UnixNano(),
+		sp.seq%1000000)
+	path := filepath.Join(sp.dir, name)
+
+	err := writeSynced(path+".tmp", body)
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+
+	sp.files = append(sp.files, name)
+	sp.sizes[name] = int64(len(body))
+	sp.size += int64(len(body))
+
+	return nil
+
+}
+
+// Write a file and sync it to disk, so it's complete once renamed.
+func writeSynced(path string, body []byte) error {
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(body)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+
+}
+
+// Oldest body, ok is false if the spool is empty.  The body stays in the
+// spool until it's removed.
+func (sp *Spool) Oldest() (name string, body []byte, ok bool, err error) {
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if len(sp.files) == 0 {
+		return "", nil, false, nil
+	}
+
+	name = sp.files[0]
+	body, err = ioutil.ReadFile(filepath.Join(sp.dir, name))
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	return name, body, true, nil
+
+}
+
+// Remove a body once it's been sent.
+func (sp *Spool) Remove(name string) error {
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	for i, v := range sp.files {
+		if v == name {
+			sp.files = append(sp.files[:i], sp.files[i+1:]...)
+			sp.size -= sp.sizes[name]
+			delete(sp.sizes, name)
+			break
+		}
+	}
+
+	err := os.Remove(filepath.Join(sp.dir, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+
+}
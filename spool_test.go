@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sp, err := NewSpool(dir, 20, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []string{"one", "two", "three"} {
+		if err := sp.Put([]byte(v)); err != nil {
+			t.Errorf("Put %s: %s", v, err.Error())
+		}
+	}
+
+	// File limit.
+	if err := sp.Put([]byte("four")); err != ErrSpoolFull {
+		t.Errorf("Expected full spool, got %v", err)
+	}
+
+	// Partial write from a crash, and a restart.
+	ioutil.WriteFile(filepath.Join(dir, "x.json.tmp"), []byte("x"), 0644)
+	sp, err = NewSpool(dir, 20, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, size := sp.Len(); n != 3 || size != 11 {
+		t.Errorf("Reopened with %d files, %d bytes", n, size)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x.json.tmp")); err == nil {
+		t.Errorf("Temporary file not removed")
+	}
+
+	// Byte limit.
+	if err := sp.Put([]byte("0123456789")); err != ErrSpoolFull {
+		t.Errorf("Expected full spool, got %v", err)
+	}
+
+	// Oldest first.
+	for _, v := range []string{"one", "two", "three"} {
+		name, body, ok, err := sp.Oldest()
+		if err != nil || !ok || string(body) != v {
+			t.Errorf("Expected %s, got %s %v %v", v, body, ok, err)
+		}
+		sp.Remove(name)
+	}
+	if _, _, ok, _ := sp.Oldest(); ok {
+		t.Errorf("Spool not empty")
+	}
+	if n, size := sp.Len(); n != 0 || size != 0 {
+		t.Errorf("Emptied with %d files, %d bytes", n, size)
+	}
+
+}
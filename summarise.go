@@ -0,0 +1,187 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Flush triggers, as reported to the emit function.
+const (
+	FlushInterval = "interval"
+	FlushElements = "elements"
+	FlushBytes    = "bytes"
+	FlushShutdown = "shutdown"
+)
+
+// When a Summary is flushed.  It's flushed every Interval, or as soon as
+// it holds MaxElements Nodes and Edges, or its estimated serialised size
+// reaches MaxBytes.  Zero limits are ignored, as is a zero or negative
+// Interval, leaving only the size limits.
+type FlushPolicy struct {
+	Interval    time.Duration
+	MaxElements int
+	MaxBytes    int
+}
+
+// Trigger which says a summary should be flushed now, or empty.
+func (p FlushPolicy) Full(sum *Summary) string {
+	if p.MaxElements > 0 && len(sum.Nodes)+len(sum.Edges) >= p.MaxElements {
+		return FlushElements
+	}
+	if p.MaxBytes > 0 && sum.Size >= p.MaxBytes {
+		return FlushBytes
+	}
+	return ""
+}
+
+// Summariser merges element updates into Summaries, handing each one on
+// when the flush policy says so.  Elements are sharded on their Node/Edge
+// key with a consistent hash, so every key has exactly one owning shard
+// and appears once per flush, while the shards run in parallel.
+type Summariser struct {
+	shards []chan Batch
+	policy FlushPolicy
+	emit   func(*Summary, string)
+	wg     sync.WaitGroup
+}
+
+// Create a summariser with n shards, emit is called from the shard
+// goroutines with each non-empty Summary and the trigger which fired.
+func NewSummariser(n int, policy FlushPolicy,
+	emit func(*Summary, string)) *Summariser {
+
+	if n < 1 {
+		n = 1
+	}
+
+	s := &Summariser{
+		shards: make([]chan Batch, n),
+		policy: policy,
+		emit:   emit,
+	}
+	for i := range s.shards {
+		s.shards[i] = make(chan Batch, 100)
+	}
+
+	return s
+
+}
+
+// Start the shard goroutines.
+func (s *Summariser) Start() {
+	for i := range s.shards {
+		s.wg.Add(1)
+		go s.run(s.shards[i])
+	}
+}
+
+// Stop the shards, once every shard has flushed what it holds.  Nothing
+// may be added after Close.
+func (s *Summariser) Close() {
+	for _, v := range s.shards {
+		close(v)
+	}
+	s.wg.Wait()
+}
+
+// Jump consistent hash (Lamping & Veach), maps a key to one of n buckets
+// moving as few keys as possible if n changes.
+func jumpHash(key uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) *
+			(float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// Shard which owns an element.
+func (s *Summariser) Shard(v Summarisable) int {
+	return jumpHash(v.Hash(), len(s.shards))
+}
+
+// Split a batch across the shards which own its elements.
+func (s *Summariser) Add(b Batch) {
+
+	if len(s.shards) == 1 {
+		s.shards[0] <- b
+		return
+	}
+
+	parts := make([][]Summarisable, len(s.shards))
+	for _, v := range b.data {
+		i := s.Shard(v)
+		parts[i] = append(parts[i], v)
+	}
+
+	for i, v := range parts {
+		if len(v) > 0 {
+			s.shards[i] <- Batch{data: v, tm: b.tm}
+		}
+	}
+
+}
+
+func (s *Summariser) run(queue chan Batch) {
+
+	defer s.wg.Done()
+
+	sum := NewSummary()
+
+	// Hand over the summary, and start a new one.
+	flush := func(trigger string) {
+		done := sum
+		sum = NewSummary()
+		s.emit(&done, trigger)
+	}
+
+	// No interval, no ticks.
+	var tick <-chan time.Time
+	if s.policy.Interval > 0 {
+		tck := time.NewTicker(s.policy.Interval)
+		defer tck.Stop()
+		tick = tck.C
+	}
+
+	for {
+
+		select {
+
+			// Get batch from queue
+		case ne, ok := <-queue:
+
+			// Closed, flush the last summary.
+			if !ok {
+				if len(sum.Nodes) > 0 || len(sum.Edges) > 0 {
+					flush(FlushShutdown)
+				}
+				return
+			}
+
+			// Add data to summary
+			for _, v := range ne.data {
+				v.Update(&sum, ne.tm)
+			}
+
+			// Don't wait for the interval if it's full.
+			if trigger := s.policy.Full(&sum); trigger != "" {
+				flush(trigger)
+			}
+
+			// Every interval, send summary.
+		case <-tick:
+
+			// If nothing, just continue
+			if len(sum.Nodes) == 0 && len(sum.Edges) == 0 {
+				continue
+			}
+
+			flush(FlushInterval)
+
+		}
+
+	}
+
+}
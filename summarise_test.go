@@ -0,0 +1,216 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSummariserShards(t *testing.T) {
+
+	s := NewSummariser(4, FlushPolicy{Interval: time.Hour},
+		func(*Summary, string) {})
+
+	// Properties go to the shard owning their node or edge.
+	n := Node{"www.example.org", "hostname"}
+	e := Edge{"10.0.2.15", "www.example.org", "dnsfailure"}
+
+	if s.Shard(&n) != s.Shard(&NodeProperty{n, "display", "x"}) {
+		t.Errorf("Node property sharded away from node")
+	}
+	if s.Shard(&e) != s.Shard(&EdgeProperty{e, "rcode", "NXDOMAIN"}) {
+		t.Errorf("Edge property sharded away from edge")
+	}
+
+	// Keys spread over the shards, and stay put as shards are added.
+	used := map[int]bool{}
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		k := Node{"10.0." + string(rune('a'+i%26)) + "." +
+			time.Duration(i).String(), "ip"}
+		used[s.Shard(&k)] = true
+		if jumpHash(k.Hash(), 4) != jumpHash(k.Hash(), 5) {
+			moved++
+		}
+	}
+	if len(used) != 4 {
+		t.Errorf("Only %d shards used", len(used))
+	}
+	if moved > 300 {
+		t.Errorf("%d of 1000 keys moved adding a shard", moved)
+	}
+
+}
+
+func TestSummariserMerge(t *testing.T) {
+
+	out := make(chan *Summary, 100)
+	s := NewSummariser(4, FlushPolicy{Interval: 20 * time.Millisecond},
+		func(sum *Summary, trigger string) {
+			if trigger != FlushInterval {
+				t.Errorf("Unexpected trigger %s", trigger)
+			}
+			out <- sum
+		})
+	s.Start()
+
+	tm := time.Now()
+	batch := []Summarisable{
+		&Node{"10.0.2.15", "ip"},
+		&Node{"8.8.8.8", "ip"},
+		&Edge{"10.0.2.15", "8.8.8.8", "ipflow"},
+	}
+
+	// Same elements from several batches in one window.
+	for i := 0; i < 6; i++ {
+		s.Add(Batch{data: batch, tm: tm})
+	}
+
+	nodes := map[Node]int{}
+	edges := map[Edge]int{}
+
+	timeout := time.After(time.Second)
+	for len(nodes) < 2 || len(edges) < 1 {
+		select {
+		case sum := <-out:
+			for k, v := range sum.Nodes {
+				if _, ok := nodes[k]; ok {
+					t.Errorf("Node %v emitted twice", k)
+				}
+				nodes[k] = v.Count
+			}
+			for k, v := range sum.Edges {
+				if _, ok := edges[k]; ok {
+					t.Errorf("Edge %v emitted twice", k)
+				}
+				edges[k] = v.Count
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for summaries")
+		}
+	}
+
+	if nodes[Node{"10.0.2.15", "ip"}] != 6 ||
+		edges[Edge{"10.0.2.15", "8.8.8.8", "ipflow"}] != 6 {
+		t.Errorf("Counts not merged: %v %v", nodes, edges)
+	}
+
+}
+
+func TestFlushPolicy(t *testing.T) {
+
+	type flush struct {
+		sum     *Summary
+		trigger string
+	}
+	out := make(chan flush, 100)
+
+	// One shard, flushes on size before the interval.
+	s := NewSummariser(1, FlushPolicy{Interval: time.Hour, MaxElements: 3},
+		func(sum *Summary, trigger string) {
+			out <- flush{sum, trigger}
+		})
+	s.Start()
+
+	tm := time.Now()
+	for i := 0; i < 5; i++ {
+		s.Add(Batch{data: []Summarisable{
+			&Node{"10.0.2." + strconv.Itoa(i), "ip"},
+		}, tm: tm})
+	}
+
+	select {
+	case f := <-out:
+		if f.trigger != FlushElements || len(f.sum.Nodes) != 3 {
+			t.Errorf("Flushed %d nodes on %s", len(f.sum.Nodes),
+				f.trigger)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Not flushed on element count")
+	}
+
+	p := FlushPolicy{MaxBytes: 1000}
+	sum := NewSummary()
+	for i := 0; i < 3; i++ {
+		(&Edge{"10.0.2.15", "10.0.0." + strconv.Itoa(i), "ipflow"}).
+			Update(&sum, tm)
+		(&Edge{"10.0.2.15", "10.0.0." + strconv.Itoa(i), "ipflow"}).
+			Update(&sum, tm)
+	}
+	if p.Full(&sum) != "" {
+		t.Errorf("Full at %d bytes", sum.Size)
+	}
+	for i := 3; i < 6; i++ {
+		(&Edge{"10.0.2.15", "10.0.0." + strconv.Itoa(i), "ipflow"}).
+			Update(&sum, tm)
+	}
+	if p.Full(&sum) != FlushBytes {
+		t.Errorf("Not full at %d bytes", sum.Size)
+	}
+
+}
+
+func TestSummariserClose(t *testing.T) {
+
+	var mu sync.Mutex
+	nodes := 0
+	var triggers []string
+	s := NewSummariser(4, FlushPolicy{Interval: time.Hour},
+		func(sum *Summary, trigger string) {
+			mu.Lock()
+			defer mu.Unlock()
+			nodes += len(sum.Nodes)
+			triggers = append(triggers, trigger)
+		})
+	s.Start()
+
+	for i := 0; i < 100; i++ {
+		s.Add(Batch{
+			data: []Summarisable{&Node{strconv.Itoa(i), "ip"}},
+			tm:   time.Now(),
+		})
+	}
+
+	// Nothing is due for an hour, Close flushes it all anyway.
+	s.Close()
+
+	if nodes != 100 {
+		t.Errorf("%d of 100 nodes flushed", nodes)
+	}
+	for _, v := range triggers {
+		if v != FlushShutdown {
+			t.Errorf("Unexpected trigger %s", v)
+		}
+	}
+
+}
+
+func TestFlushNoInterval(t *testing.T) {
+
+	var mu sync.Mutex
+	triggers := []string{}
+
+	// Size limits only.
+	s := NewSummariser(1, FlushPolicy{MaxElements: 2},
+		func(sum *Summary, trigger string) {
+			mu.Lock()
+			triggers = append(triggers, trigger)
+			mu.Unlock()
+		})
+	s.Start()
+
+	tm := time.Now()
+	for i := 0; i < 3; i++ {
+		s.Add(Batch{data: []Summarisable{
+			&Node{"10.0.2." + strconv.Itoa(i), "ip"},
+		}, tm: tm})
+	}
+	s.Close()
+
+	if len(triggers) != 2 || triggers[0] != FlushElements ||
+		triggers[1] != FlushShutdown {
+		t.Errorf("Flushed on %v", triggers)
+	}
+
+}
@@ -3,32 +3,32 @@ package main
 
 //
 // Gaffer loader for the analytics cluster.  Takes events on input queue
-// and restructures for loading into Gaffer.  Multiple RDF statements are
-// loaded per event.
+// and restructures for loading into Gaffer, or another graph store
+// selected by URL (see sink.go).  Multiple RDF statements are loaded per
+// event.
 //
 // No output queues are used.
 //
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
-	dt "github.com/trustnetworks/analytics-common/datatypes"
 	"github.com/trustnetworks/analytics-common/utils"
 	"github.com/trustnetworks/analytics-common/worker"
-	"io/ioutil"
-	"net/http"
 	"os"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"context"
 )
 
 const (
 	pgm                 = "threat-graph" // Program name
-	MaxIdleConns        = 50          // Maximum number of idle connection to leave in pool
-	MaxIdleConnsPerHost = 5           // Maximum number of idle connection to leave in pool
-	CnxTimeout          = 5           // How long before a connection timesout
-	RefreshSecs         = 10          // How many requests to make before we refresh the cnx pool
+	Senders             = 6           // Number of sender goroutines
 )
 
 type Batch struct {
@@ -38,19 +38,221 @@ type Batch struct {
 
 // Worker local state.
 type work struct {
-	url   string
-	queue chan interface{}
-	summaryQueue chan Batch
+	sink  GraphSink
+	queue chan []interface{}
+	summariser *Summariser
+
+	// Senders, and their deadline for draining the queue at shutdown.
+	senders sync.WaitGroup
+	drain   context.Context
+	stop    context.CancelFunc
+
+	shutdownTimeout time.Duration
+
+	// Retry policy, and the breaker which pauses sending and consuming
+	// while the sink is down.
+	backoff Backoff
+	breaker *Breaker
+	ctx     context.Context
+
+	// Batches spooled to disk when the sink can't be reached, and the
+	// goroutine replaying them.
+	spool          *Spool
+	spoolReplay    time.Duration
+	spooledBatches int64
+	replayers      sync.WaitGroup
+
+	// Batches and elements which couldn't be sent.
+	droppedBatches  int64
+	droppedElements int64
 
 	eventLatency *prometheus.SummaryVec
+	flushes      *prometheus.CounterVec
+	breakerOpen  prometheus.Gauge
 	recvLabels   prometheus.Labels
 }
 
+// Integer from an environment variable.
+func getenvInt(name string, def int) (int, error) {
+	v, err := strconv.Atoi(utils.Getenv(name, strconv.Itoa(def)))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	return v, nil
+}
+
+// Float from an environment variable.
+func getenvFloat(name string, def float64) (float64, error) {
+	v, err := strconv.ParseFloat(utils.Getenv(name,
+		strconv.FormatFloat(def, 'g', -1, 64)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	return v, nil
+}
+
+// Duration from an environment variable e.g. 100ms.
+func getenvDuration(name string, def string) (time.Duration, error) {
+	v, err := time.ParseDuration(utils.Getenv(name, def))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	return v, nil
+}
+
+// Configure how events are described, from the environment.  Shared by
+// the loader and export.
+func configureGraph() error {
+
+	// Domain extraction, DOMAIN_MODE is psl or regexp.  An updated
+	// Public Suffix List can be supplied in PUBLIC_SUFFIX_LIST.
+	err := SetDomainMode(utils.Getenv("DOMAIN_MODE", DomainModePsl))
+	if err != nil {
+		return err
+	}
+	if psl := utils.Getenv("PUBLIC_SUFFIX_LIST", ""); psl != "" {
+		err = LoadSuffixList(psl)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Optional graph elements.
+	Options.Services = utils.Getenv("SERVICE_NODES", "false") == "true"
+	Options.ScopePrivate =
+		utils.Getenv("SCOPE_PRIVATE_IPS", "false") == "true"
+	Options.SeenProperties =
+		utils.Getenv("SEEN_PROPERTIES", "false") == "true"
+	// Deterministic element order, for diffing and replaying payloads.
+	Options.Sorted = utils.Getenv("SORTED_OUTPUT", "false") == "true"
+
+	// Distinct-neighbour sketches by edge group e.g.
+	// dnsquery,webrequest.
+	Options.SketchGroups = map[string]bool{}
+	for _, v := range strings.Split(utils.Getenv("SKETCH_GROUPS", ""), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			Options.SketchGroups[v] = true
+		}
+	}
+
+	// Time bucket, TIME_BUCKETS overrides by group e.g.
+	// ipflow=MINUTE,dnsquery=MINUTE.
+	err = SetTimeBuckets(utils.Getenv("TIME_BUCKET", "HOUR"),
+		utils.Getenv("TIME_BUCKETS", ""))
+	if err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
 // Initialisation.
 func (s *work) init() error {
 
-	// Get Gaffer location form GAFFER_URL environment variable.
-	s.url = utils.Getenv("GAFFER_URL", "http://gaffer-threat:8080/rest/v1")
+	var err error
+
+	// Graph store, selected by SINK_URL scheme.  Gaffer by default,
+	// at GAFFER_URL.
+	sink := utils.Getenv("SINK_URL",
+		utils.Getenv("GAFFER_URL", "http://gaffer-threat:8080/rest/v1"))
+	s.sink, err = OpenSink(sink)
+	if err != nil {
+		return err
+	}
+
+	err = configureGraph()
+	if err != nil {
+		return err
+	}
+
+	// Summary shards, one per core by default, and flush policy.  A zero
+	// SUMMARY_INTERVAL flushes on size only.
+	shards, err := getenvInt("SUMMARY_SHARDS", runtime.NumCPU())
+	if err != nil {
+		return err
+	}
+	var policy FlushPolicy
+	policy.Interval, err = getenvDuration("SUMMARY_INTERVAL", "100ms")
+	if err != nil {
+		return err
+	}
+	policy.MaxElements, err = getenvInt("SUMMARY_MAX_ELEMENTS", 0)
+	if err != nil {
+		return err
+	}
+	policy.MaxBytes, err = getenvInt("SUMMARY_MAX_BYTES", 0)
+	if err != nil {
+		return err
+	}
+	if policy.Interval <= 0 && policy.MaxElements <= 0 &&
+		policy.MaxBytes <= 0 {
+		return fmt.Errorf("SUMMARY_INTERVAL: zero needs SUMMARY_MAX_ELEMENTS or SUMMARY_MAX_BYTES")
+	}
+	s.summariser = NewSummariser(shards, policy, s.flush)
+
+	// How long to spend sending queued data at shutdown.
+	s.shutdownTimeout, err = getenvDuration("SHUTDOWN_TIMEOUT", "20s")
+	if err != nil {
+		return err
+	}
+
+	// Retries back off exponentially with jitter, until RETRY_MAX_ELAPSED.
+	s.backoff.Initial, err = getenvDuration("RETRY_INITIAL", "500ms")
+	if err != nil {
+		return err
+	}
+	s.backoff.Max, err = getenvDuration("RETRY_MAX", "30s")
+	if err != nil {
+		return err
+	}
+	s.backoff.Multiplier, err = getenvFloat("RETRY_MULTIPLIER", 2)
+	if err != nil {
+		return err
+	}
+	s.backoff.Jitter, err = getenvFloat("RETRY_JITTER", 0.5)
+	if err != nil {
+		return err
+	}
+	s.backoff.MaxElapsed, err = getenvDuration("RETRY_MAX_ELAPSED", "5m")
+	if err != nil {
+		return err
+	}
+
+	// Circuit breaker, opens after BREAKER_FAILURES consecutive failures.
+	s.breaker = &Breaker{}
+	s.breaker.Failures, err = getenvInt("BREAKER_FAILURES", 10)
+	if err != nil {
+		return err
+	}
+	s.breaker.Cooldown, err = getenvDuration("BREAKER_COOLDOWN", "30s")
+	if err != nil {
+		return err
+	}
+
+	// Spool for batches the sink won't take, off unless SPOOL_DIR is set.
+	if dir := utils.Getenv("SPOOL_DIR", ""); dir != "" {
+		maxBytes, err := getenvInt("SPOOL_MAX_BYTES", 1<<30)
+		if err != nil {
+			return err
+		}
+		maxFiles, err := getenvInt("SPOOL_MAX_FILES", 100000)
+		if err != nil {
+			return err
+		}
+		s.spoolReplay, err = getenvDuration("SPOOL_REPLAY_INTERVAL", "30s")
+		if err != nil {
+			return err
+		}
+		if s.spoolReplay <= 0 {
+			return fmt.Errorf("SPOOL_REPLAY_INTERVAL: must be positive")
+		}
+		s.spool, err = NewSpool(dir, int64(maxBytes), maxFiles)
+		if err != nil {
+			return err
+		}
+	}
+
 	s.recvLabels = prometheus.Labels{"store": pgm}
 	s.eventLatency = prometheus.NewSummaryVec(
 		prometheus.SummaryOpts{
@@ -60,7 +262,33 @@ func (s *work) init() error {
 		[]string{"store"},
 	)
 
+	s.flushes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "summary_flushes",
+			Help: "Summaries flushed, by trigger",
+		},
+		[]string{"trigger"},
+	)
+
+	s.breakerOpen = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "sink_breaker_open",
+			Help: "1 while the sink circuit breaker is open",
+		},
+	)
+	s.breaker.OnChange = func(open bool) {
+		if open {
+			utils.Log("Sink unavailable, pausing.")
+			s.breakerOpen.Set(1)
+		} else {
+			utils.Log("Sink available, resuming.")
+			s.breakerOpen.Set(0)
+		}
+	}
+
 	prometheus.MustRegister(s.eventLatency)
+	prometheus.MustRegister(s.flushes)
+	prometheus.MustRegister(s.breakerOpen)
 
 	return nil
 
@@ -69,7 +297,10 @@ func (s *work) init() error {
 // Handle a single JSON object.
 func (h *work) Handle(msg []uint8, w *worker.Worker) error {
 
-	var e dt.Event
+	// Don't take more while the sink is down.
+	h.breaker.Wait(h.ctx.Done())
+
+	var e Event
 
 	// Convert JSON object to internal object.
 	err := json.Unmarshal(msg, &e)
@@ -89,11 +320,11 @@ func (h *work) Handle(msg []uint8, w *worker.Worker) error {
 		return nil
 	}
 
-	// Send for Gaffer outputting
-	h.summaryQueue <- Batch {
+	// Send for outputting
+	h.summariser.Add(Batch {
 		data: elements,
 		tm: tm,
-	}
+	})
 
 	// Record latency of event
 	ts := time.Now().UnixNano()
@@ -108,7 +339,7 @@ func (h *work) Handle(msg []uint8, w *worker.Worker) error {
 
 }
 
-func (h *work) recordLatency(ts int64, e dt.Event) {
+func (h *work) recordLatency(ts int64, e Event) {
 	eTime, err := time.Parse(time.RFC3339, e.Time)
 	if err != nil {
 		utils.Log("Date Parse Error: %s", err.Error())
@@ -117,125 +348,248 @@ func (h *work) recordLatency(ts int64, e dt.Event) {
 	h.eventLatency.With(h.recvLabels).Observe(float64(latency))
 }
 
-func (s *work) output(elements interface{}) error {
+// Queue elements for the senders.  Past the drain deadline nothing will
+// send them, so they're spooled or dropped instead.
+func (s *work) output(elements []interface{}) error {
 
-	body := &dt.Bundle{
-		"class":               "uk.gov.gchq.gaffer.operation.impl.add.AddElements",
-		"validate":            true,
-		"skipInvalidElements": false,
-		"input":               elements,
+	select {
+	case s.queue <- elements:
+	case <-s.drain.Done():
+		s.failed(elements)
 	}
 
-	s.queue <- body
-
 	return nil
 
 }
 
-func (s *work) sender(client *http.Client) error {
+// Record a batch which won't be sent.
+func (s *work) drop(b []interface{}) {
+	atomic.AddInt64(&s.droppedBatches, 1)
+	atomic.AddInt64(&s.droppedElements, int64(len(b)))
+}
 
-	for {
+// Send batches from the queue until it's closed.  Once the drain
+// deadline has passed, whatever is left is dropped.
+func (s *work) sender() {
 
-		b := <-s.queue
+	defer s.senders.Done()
 
-		j, err := json.Marshal(&b)
-		if err != nil {
-			utils.Log("Couldn't marshal json: %s", err.Error())
-			return nil
+	for b := range s.queue {
+
+		if s.drain.Err() != nil {
+			s.failed(b)
+			continue
 		}
 
-		retries := 50
-		for {
+		err := s.send(b)
+		if err != nil && !Retryable(err) {
+			// The sink won't take it, however many times it's sent.
+			utils.Log("Batch rejected: %s", err.Error())
+			s.drop(b)
+		} else if err != nil {
+			s.failed(b)
+		}
 
-			req, _ := http.NewRequest("PUT",
-				s.url+"/graph/doOperation/add/elements",
-				strings.NewReader(string(j)))
-			req.ContentLength = int64(len(j))
-			req.Header.Set("Content-Type", "application/json")
+	}
 
-			response, err := client.Do(req)
-			if err != nil {
-				utils.Log("Couldn't make HTTP request: %s",
-					err.Error())
-				retries--
-				if retries <= 0 {
-					utils.Log("Give up.")
-					break
-				} else {
-					utils.Log("Retrying...")
-					time.Sleep(time.Second)
-					continue
-				}
-			}
+}
 
-			rtn, _ := ioutil.ReadAll(response.Body)
-			response.Body.Close()
+// A batch couldn't be sent, spool it if we can.
+func (s *work) failed(b []interface{}) {
 
-			if response.StatusCode == 204 {
-				break
-			}
+	if s.spool != nil {
+		j, err := json.Marshal(b)
+		if err == nil {
+			err = s.spool.Put(j)
+		}
+		if err == nil {
+			atomic.AddInt64(&s.spooledBatches, 1)
+			return
+		}
+		utils.Log("Couldn't spool batch: %s", err.Error())
+	}
+
+	s.drop(b)
+
+}
+
+// Wait before retrying, false if the drain deadline passes first.
+func (s *work) wait(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-s.drain.Done():
+		return false
+	}
+}
+
+// Write once the circuit breaker allows it, recording the outcome.
+func (s *work) try(b []interface{}) error {
+
+	if !s.breaker.Wait(s.drain.Done()) {
+		return s.drain.Err()
+	}
+
+	err := s.sink.Write(b)
+	if err != nil && Retryable(err) {
+		s.breaker.Failure()
+	} else {
+		// Rejected, but the sink is up.
+		s.breaker.Success()
+	}
+	return err
+
+}
+
+// Write a batch, backing off and retrying while the error is
+// retryable.  Returns the last error if it wasn't sent.
+func (s *work) send(b []interface{}) error {
+
+	start := time.Now()
+	for n := 0; ; n++ {
+
+		err := s.try(b)
+		if err == nil || !Retryable(err) || s.drain.Err() != nil {
+			return err
+		}
+
+		d := s.backoff.Delay(n)
+		if s.backoff.MaxElapsed > 0 &&
+			time.Since(start)+d > s.backoff.MaxElapsed {
+			utils.Log("%s", err.Error())
+			utils.Log("Give up.")
+			return err
+		}
+
+		utils.Log("%s, retrying in %s", err.Error(), d)
+		if !s.wait(d) {
+			return err
+		}
+
+	}
+
+}
+
+// Re-send spooled batches oldest first, stopping at the first failure
+// worth retrying.  Returns the number taken from the spool.
+func (s *work) replay() int {
+
+	n := 0
+	for s.drain.Err() == nil {
+
+		name, j, ok, err := s.spool.Oldest()
+		if err != nil {
+			utils.Log("Couldn't read spool: %s", err.Error())
+			return n
+		}
+		if !ok {
+			return n
+		}
 
-			utils.Log("Gaffer PUT error, status %d",
-				response.Status)
-			utils.Log("Error: %s", rtn)
-			retries--
-			if retries <= 0 {
-				utils.Log("Give up.")
-				break
-			} else {
-				utils.Log("Retrying...")
-				time.Sleep(time.Second)
+		// Undecodable, e.g. truncated by a crash, it'll never send.
+		var b []interface{}
+		err = json.Unmarshal(j, &b)
+		if err != nil {
+			utils.Log("Couldn't decode spooled batch %s: %s", name,
+				err.Error())
+			atomic.AddInt64(&s.droppedBatches, 1)
+		} else {
+			err = s.try(b)
+			if err != nil && Retryable(err) {
+				utils.Log("Spool replay: %s", err.Error())
+				return n
+			}
+			if err != nil {
+				utils.Log("Spooled batch rejected: %s", err.Error())
+				atomic.AddInt64(&s.droppedBatches, 1)
 			}
+		}
 
+		err = s.spool.Remove(name)
+		if err != nil {
+			utils.Log("Couldn't remove spooled batch: %s",
+				err.Error())
+			return n
 		}
+		n++
 
 	}
 
-	return nil
+	return n
+
 }
 
-func (s *work) summarise() error {
+// Replay the spool every interval, until shutdown.
+func (s *work) replayer(interval time.Duration) {
 
-	sum := NewSummary()
+	defer s.replayers.Done()
 
-	tck := time.NewTicker(100 * time.Millisecond).C
-	
-	for {
+	tck := time.NewTicker(interval)
+	defer tck.Stop()
 
+	for {
 		select {
+		case <-tck.C:
+			if n := s.replay(); n > 0 {
+				files, size := s.spool.Len()
+				utils.Log("Replayed %d spooled batches, %d (%d bytes) left.",
+					n, files, size)
+			}
+		case <-s.drain.Done():
+			return
+		}
+	}
 
-			// Get batch from queue
-		case ne := <-s.summaryQueue:
+}
 
-			// Add data to summary
-			for _, v := range ne.data {
-				v.Update(&sum, ne.tm)
-				
-			}
+// Shut down once input has stopped: flush every summary shard, and give
+// the senders until the timeout to empty the queue.  The timeout covers
+// the flush too, which waits on the queue if the sink is down.
+func (s *work) shutdown(timeout time.Duration) {
 
-			// 10 times a second, send summary.
-		case <- tck:
+	utils.Log("Shutting down...")
 
-			// Send summary to threatgraph
-			grp, err := sum.ToGraph()
+	tmr := time.AfterFunc(timeout, s.stop)
 
-			// If no graph, just continue
-			if len(grp) == 0 {
-				continue
-			}
+	s.summariser.Close()
+	close(s.queue)
 
-			if err == nil {
-				s.output(grp)
-			}
+	s.senders.Wait()
+	tmr.Stop()
+	s.stop()
 
-			// Reset summary
-			sum = NewSummary()
+	// Nothing may be writing when the sink closes.
+	s.replayers.Wait()
 
-		}
+	err := s.sink.Close()
+	if err != nil {
+		utils.Log("Couldn't close sink: %s", err.Error())
+	}
+
+	utils.Log("Shutdown complete, dropped %d elements in %d batches, spooled %d batches.",
+		atomic.LoadInt64(&s.droppedElements),
+		atomic.LoadInt64(&s.droppedBatches),
+		atomic.LoadInt64(&s.spooledBatches))
 
+}
+
+// Convert a summary to graph elements and queue for sending.
+func (s *work) flush(sum *Summary, trigger string) {
+
+	s.flushes.With(prometheus.Labels{"trigger": trigger}).Inc()
+
+	grp, err := sum.ToGraph()
+	if err != nil {
+		utils.Log("Couldn't convert summary: %s", err.Error())
+		return
 	}
 
-	return nil
+	// If no graph, nothing to do
+	if len(grp) == 0 {
+		return
+	}
+
+	s.output(grp)
 
 }
 
@@ -245,36 +599,41 @@ func main() {
 	var s work
 	utils.LogPgm = pgm
 
-	s.init()
-
-	s.queue = make(chan interface{}, 100)
-	s.summaryQueue = make(chan Batch, 100)
+	// Offline conversion, see export.go.
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		err := export(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "export: %s\n", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
 
-	// Create an HTTP transport and client for Gaffer.
-	tp := &http.Transport{
-		MaxIdleConnsPerHost: MaxIdleConnsPerHost,
-		MaxIdleConns:        MaxIdleConns,
+	err := s.init()
+	if err != nil {
+		utils.Log("init: %s", err.Error())
+		return
 	}
-	client := &http.Client{
-		Transport: tp,
-		Timeout:   CnxTimeout * time.Second,
+
+	s.queue = make(chan []interface{}, 100)
+	// Create worker senders
+	s.drain, s.stop = context.WithCancel(context.Background())
+	for i := 0; i < Senders; i++ {
+		s.senders.Add(1)
+		go s.sender()
 	}
 
-	// Refresh idle connections every Xs
-	go func() {
-		for range time.Tick(RefreshSecs * time.Second) {
-			tp.CloseIdleConnections()
+	// Send what's left from the last run before anything new.
+	if s.spool != nil {
+		if files, _ := s.spool.Len(); files > 0 {
+			n := s.replay()
+			utils.Log("Replayed %d of %d spooled batches.", n, files)
 		}
-	}()
-	// Create 5 worker senders
-	for i := 0; i <= 5; i++ {
-		go s.sender(client)
+		s.replayers.Add(1)
+		go s.replayer(s.spoolReplay)
 	}
 
-	// Create 5 worker senders
-	for i := 0; i <= 5; i++ {
-		go s.summarise()
-	}
+	s.summariser.Start()
 
 	var input string
 	var output []string
@@ -291,7 +650,7 @@ func main() {
 	ctx, cancel := utils.ContextWithSigterm(ctx)
 	defer cancel()
 	
-	err := w.Initialise(ctx, input, output, pgm)
+	err = w.Initialise(ctx, input, output, pgm)
 	if err != nil {
 		utils.Log("init: %s", err.Error())
 		return
@@ -300,11 +659,15 @@ func main() {
 	utils.Log("Initialisation complete.")
 
 	// Invoke Wye event handling.
+	s.ctx = ctx
 	err = w.Run(ctx, &s)
 	if err != nil {
 		utils.Log("error: Event handling failed with err: %s", err.Error())
 	}
 
+	// Input has stopped, send what we have.
+	s.shutdown(s.shutdownTimeout)
+
 }
 
 
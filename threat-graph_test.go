@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Worker sending to a test server, with n batches of 2 elements queued.
+func testWork(url string, n int) *work {
+
+	s := &work{
+		sink:    NewGafferSink(url),
+		queue:   make(chan []interface{}, 100),
+		backoff: Backoff{Initial: 10 * time.Millisecond, Multiplier: 2},
+		breaker: &Breaker{},
+	}
+	s.summariser = NewSummariser(1, FlushPolicy{Interval: time.Hour},
+		func(*Summary, string) {})
+	s.summariser.Start()
+
+	s.drain, s.stop = context.WithCancel(context.Background())
+	for i := 0; i < 2; i++ {
+		s.senders.Add(1)
+		go s.sender()
+	}
+
+	for i := 0; i < n; i++ {
+		s.queue <- []interface{}{1, 2}
+	}
+
+	return s
+
+}
+
+func TestShutdownDrains(t *testing.T) {
+
+	var got int64
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&got, 1)
+			w.WriteHeader(204)
+		}))
+	defer srv.Close()
+
+	s := testWork(srv.URL, 20)
+	s.shutdown(5 * time.Second)
+
+	if got != 20 || s.droppedBatches != 0 {
+		t.Errorf("%d sent, %d dropped", got, s.droppedBatches)
+	}
+
+}
+
+func TestShutdownDeadline(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(500)
+		}))
+	defer srv.Close()
+
+	s := testWork(srv.URL, 5)
+
+	start := time.Now()
+	s.shutdown(100 * time.Millisecond)
+
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Shutdown took %s", time.Since(start))
+	}
+	if s.droppedBatches != 5 || s.droppedElements != 10 {
+		t.Errorf("%d batches, %d elements dropped", s.droppedBatches,
+			s.droppedElements)
+	}
+
+}
+
+func TestShutdownFullQueue(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(503)
+		}))
+	defer srv.Close()
+
+	// Senders retrying forever, with the queue full.
+	s := testWork(srv.URL, 102)
+
+	// The final flush can't be queued.
+	s.summariser = NewSummariser(1, FlushPolicy{Interval: time.Hour},
+		func(*Summary, string) {
+			s.output([]interface{}{1, 2})
+		})
+	s.summariser.Start()
+	s.summariser.Add(Batch{
+		data: []Summarisable{&Node{"10.0.2.15", "ip"}},
+		tm:   time.Now(),
+	})
+
+	start := time.Now()
+	s.shutdown(200 * time.Millisecond)
+
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Shutdown took %s", time.Since(start))
+	}
+	if s.droppedBatches != 103 {
+		t.Errorf("%d batches dropped", s.droppedBatches)
+	}
+
+}
+
+func TestSpoolReplay(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var up int32
+	var got int64
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if atomic.LoadInt32(&up) == 0 {
+				w.WriteHeader(503)
+				return
+			}
+			atomic.AddInt64(&got, 1)
+			w.WriteHeader(204)
+		}))
+	defer srv.Close()
+
+	// Gaffer's down, everything goes to the spool.
+	s := testWork(srv.URL, 0)
+	s.spool, _ = NewSpool(dir, 0, 0)
+	for i := 0; i < 5; i++ {
+		s.queue <- []interface{}{1, 2}
+	}
+	s.shutdown(100 * time.Millisecond)
+
+	if s.droppedBatches != 0 || s.spooledBatches != 5 {
+		t.Errorf("%d dropped, %d spooled", s.droppedBatches,
+			s.spooledBatches)
+	}
+
+	// Restart when it's back.
+	atomic.StoreInt32(&up, 1)
+	s = testWork(srv.URL, 0)
+	s.spool, _ = NewSpool(dir, 0, 0)
+	if n := s.replay(); n != 5 || got != 5 {
+		t.Errorf("Replayed %d, received %d", n, got)
+	}
+	if n, _ := s.spool.Len(); n != 0 {
+		t.Errorf("%d left in spool", n)
+	}
+	s.shutdown(time.Second)
+
+}
+
+func TestSpoolCorrupt(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var got int64
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&got, 1)
+			w.WriteHeader(204)
+		}))
+	defer srv.Close()
+
+	// Empty and truncated files left by a crash, ahead of a good one.
+	ioutil.WriteFile(filepath.Join(dir, "1-000001.json"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "2-000002.json"), []byte("[1,"),
+		0644)
+	ioutil.WriteFile(filepath.Join(dir, "3-000003.json"), []byte("[1,2]"),
+		0644)
+
+	s := testWork(srv.URL, 0)
+	s.spool, _ = NewSpool(dir, 0, 0)
+	if n := s.replay(); n != 3 || got != 1 {
+		t.Errorf("Replayed %d, received %d", n, got)
+	}
+	if n, _ := s.spool.Len(); n != 0 {
+		t.Errorf("%d left in spool", n)
+	}
+	if s.droppedBatches != 2 {
+		t.Errorf("%d batches dropped", s.droppedBatches)
+	}
+	s.shutdown(time.Second)
+
+}
+
+// Sink which complains if it's closed mid-write.
+type closeSink struct {
+	t       *testing.T
+	writing int32
+}
+
+func (c *closeSink) Write([]interface{}) error {
+	atomic.AddInt32(&c.writing, 1)
+	time.Sleep(20 * time.Millisecond)
+	atomic.AddInt32(&c.writing, -1)
+	return nil
+}
+
+func (c *closeSink) Close() error {
+	if atomic.LoadInt32(&c.writing) != 0 {
+		c.t.Errorf("Close during Write")
+	}
+	return nil
+}
+
+func TestShutdownReplayer(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := testWork("", 0)
+	s.sink = &closeSink{t: t}
+	s.spool, _ = NewSpool(dir, 0, 0)
+	for i := 0; i < 100; i++ {
+		s.spool.Put([]byte("[1,2]"))
+	}
+
+	// Replaying when shutdown starts.
+	s.replayers.Add(1)
+	go s.replayer(time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	s.shutdown(time.Second)
+
+}
+
+func TestRejectedBatch(t *testing.T) {
+
+	var got int64
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&got, 1)
+			w.WriteHeader(400)
+		}))
+	defer srv.Close()
+
+	// Rejected batches aren't retried, or spooled.
+	s := testWork(srv.URL, 3)
+	s.shutdown(5 * time.Second)
+
+	if got != 3 || s.droppedBatches != 3 {
+		t.Errorf("%d requests, %d dropped", got, s.droppedBatches)
+	}
+
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Gaffer TimestampSet bucket widths.
+var bucketWidths = map[string]int64{
+	"SECOND": 1,
+	"MINUTE": 60,
+	"HOUR":   3600,
+	"DAY":    86400,
+}
+
+// Bucket used for element time properties, by default and by element
+// group.
+var (
+	TimeBucket       = "HOUR"
+	GroupTimeBuckets = map[string]string{}
+)
+
+// Bucket for an element group.
+func BucketFor(group string) string {
+	if b, ok := GroupTimeBuckets[group]; ok {
+		return b
+	}
+	return TimeBucket
+}
+
+// Set the default bucket, and per-group buckets from a list of the form
+// ipflow=MINUTE,dnsquery=SECOND.
+func SetTimeBuckets(def, groups string) error {
+
+	def = strings.ToUpper(def)
+	if _, ok := bucketWidths[def]; !ok {
+		return fmt.Errorf("unknown time bucket: %s", def)
+	}
+
+	gb := map[string]string{}
+	for _, v := range strings.Split(groups, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("bad group time bucket: %s", v)
+		}
+		b := strings.ToUpper(strings.TrimSpace(parts[1]))
+		if _, ok := bucketWidths[b]; !ok {
+			return fmt.Errorf("unknown time bucket: %s", b)
+		}
+		gb[strings.TrimSpace(parts[0])] = b
+	}
+
+	TimeBucket = def
+	GroupTimeBuckets = gb
+	return nil
+
+}
+
+// A run of consecutive buckets, inclusive.
+type bucketRun struct {
+	first, last int64
+}
+
+// Set of time buckets held as sorted, run-length encoded bucket numbers.
+// Times are usually bunched together, so a summary window is a run or
+// two however many events were seen.
+type TimeBuckets struct {
+	bucket string
+	width  int64
+	runs   []bucketRun
+}
+
+func NewTimeBuckets(bucket string) TimeBuckets {
+	width, ok := bucketWidths[bucket]
+	if !ok {
+		bucket = "HOUR"
+		width = bucketWidths[bucket]
+	}
+	return TimeBuckets{bucket: bucket, width: width}
+}
+
+// Bucket name e.g. HOUR.
+func (tb *TimeBuckets) Bucket() string {
+	return tb.bucket
+}
+
+// Add the bucket holding a time, returns true if it's a new bucket.
+func (tb *TimeBuckets) Add(tm time.Time) bool {
+
+	b := tm.Unix() / tb.width
+	if tm.Unix() < 0 && tm.Unix()%tb.width != 0 {
+		b--
+	}
+
+	// Find the first run which doesn't end before b-1.
+	i := 0
+	for i < len(tb.runs) && tb.runs[i].last < b-1 {
+		i++
+	}
+
+	switch {
+
+	case i == len(tb.runs) || tb.runs[i].first > b+1:
+		// Not touching any run, insert a new one.
+		tb.runs = append(tb.runs, bucketRun{})
+		copy(tb.runs[i+1:], tb.runs[i:])
+		tb.runs[i] = bucketRun{b, b}
+
+	case b >= tb.runs[i].first && b <= tb.runs[i].last:
+		return false
+
+	case b == tb.runs[i].last+1:
+		tb.runs[i].last = b
+		// May now join the next run.
+		if i+1 < len(tb.runs) && tb.runs[i+1].first == b+1 {
+			tb.runs[i].last = tb.runs[i+1].last
+			tb.runs = append(tb.runs[:i+1], tb.runs[i+2:]...)
+		}
+
+	default:
+		// b == first-1
+		tb.runs[i].first = b
+	}
+
+	return true
+
+}
+
+// Number of buckets in the set.
+func (tb *TimeBuckets) Len() int {
+	n := 0
+	for _, v := range tb.runs {
+		n += int(v.last - v.first + 1)
+	}
+	return n
+}
+
+// Start of each bucket as Unix time, in order.
+func (tb *TimeBuckets) Timestamps() []int64 {
+	ts := make([]int64, 0, tb.Len())
+	for _, v := range tb.runs {
+		for b := v.first; b <= v.last; b++ {
+			ts = append(ts, b*tb.width)
+		}
+	}
+	return ts
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimeBuckets(t *testing.T) {
+
+	base := time.Date(2018, 5, 21, 11, 3, 22, 0, time.UTC)
+
+	tb := NewTimeBuckets("HOUR")
+
+	// Hours 0, 2, 1 (joins the runs), 5, 4, then repeats.
+	hours := []int{0, 2, 1, 5, 4, 0, 5}
+	added := []bool{true, true, true, true, true, false, false}
+
+	for i, h := range hours {
+		tm := base.Add(time.Duration(h) * time.Hour)
+		if tb.Add(tm) != added[i] {
+			t.Errorf("Add hour %d: expected %v", h, added[i])
+		}
+	}
+
+	if len(tb.runs) != 2 {
+		t.Errorf("Runs not merged: %v", tb.runs)
+	}
+
+	start := base.Truncate(time.Hour).Unix()
+	exp := []int64{start, start + 3600, start + 7200, start + 4*3600,
+		start + 5*3600}
+	if ts := tb.Timestamps(); !reflect.DeepEqual(ts, exp) {
+		t.Errorf("Timestamps %v, expected %v", ts, exp)
+	}
+
+	// Same second-rounded times, one bucket.
+	tb = NewTimeBuckets("MINUTE")
+	for i := 0; i < 60; i++ {
+		tb.Add(base.Truncate(time.Minute).Add(time.Duration(i) *
+			time.Second))
+	}
+	if tb.Len() != 1 {
+		t.Errorf("%d minute buckets", tb.Len())
+	}
+
+}
+
+func TestStateSeen(t *testing.T) {
+
+	base := time.Date(2018, 5, 21, 11, 3, 22, 0, time.UTC)
+
+	st := NewState("ipflow")
+	st.Observe(base.Add(time.Minute))
+	st.Observe(base)
+	st.Observe(base.Add(2 * time.Hour))
+
+	if !st.FirstSeen.Equal(base) ||
+		!st.LastSeen.Equal(base.Add(2*time.Hour)) {
+		t.Errorf("First/last seen %s %s", st.FirstSeen, st.LastSeen)
+	}
+	if st.Count != 3 || st.Times.Len() != 2 {
+		t.Errorf("Count %d, %d buckets", st.Count, st.Times.Len())
+	}
+
+}
+
+func TestGroupTimeBuckets(t *testing.T) {
+
+	err := SetTimeBuckets("HOUR", "ipflow=minute, dnsquery=SECOND")
+	if err != nil {
+		t.Fatalf("Couldn't set buckets: %s", err.Error())
+	}
+	defer SetTimeBuckets("HOUR", "")
+
+	if b := NewState("ipflow").Times.Bucket(); b != "MINUTE" {
+		t.Errorf("ipflow bucket %s", b)
+	}
+	if b := NewState("dnsquery").Times.Bucket(); b != "SECOND" {
+		t.Errorf("dnsquery bucket %s", b)
+	}
+	if b := NewState("hasip").Times.Bucket(); b != "HOUR" {
+		t.Errorf("hasip bucket %s", b)
+	}
+
+	if SetTimeBuckets("WEEK", "") == nil {
+		t.Errorf("Unknown default bucket accepted")
+	}
+	if SetTimeBuckets("HOUR", "ipflow") == nil {
+		t.Errorf("Bad group bucket accepted")
+	}
+
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Canonical hostnames, at least two labels.
+var hostnameRegex = regexp.MustCompile(
+	"^([a-z0-9_]([a-z0-9_-]*[a-z0-9])?\\.)+[a-z0-9]([a-z0-9-]*[a-z0-9])?$")
+
+// Names taken from a server certificate.  Names holds the subject CN
+// followed by the DNS subject alternative names, without duplicates.
+type CertNames struct {
+	Names  []string
+	Issuer string
+}
+
+// Decode a base64 DER certificate from a tls_certificates event and pull
+// out the names which are useful in the graph.
+func ParseCertificate(s string) (*CertNames, error) {
+
+	der, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	cn := &CertNames{Issuer: cert.Issuer.CommonName}
+
+	seen := map[string]bool{}
+	add := func(n string) {
+		if n != "" && !seen[n] {
+			seen[n] = true
+			cn.Names = append(cn.Names, n)
+		}
+	}
+
+	// CNs are usually hostnames, but not always.
+	if strings.ContainsAny(cert.Subject.CommonName, " ") {
+		add(cert.Subject.CommonName)
+	} else {
+		add(certName(cert.Subject.CommonName))
+	}
+	for _, v := range cert.DNSNames {
+		add(certName(v))
+	}
+
+	if len(cn.Names) == 0 {
+		return nil, errors.New("certificate has no subject names")
+	}
+
+	return cn, nil
+
+}
+
+// Canonical form of a certificate name.  A wildcard is only allowed as
+// the first label, the rest is a hostname.
+func certName(n string) string {
+	if strings.HasPrefix(n, "*.") {
+		return "*." + CanonicalName(n[2:])
+	}
+	return CanonicalName(n)
+}
+
+// Hostname entity for a certificate name, on the vertex the certificate
+// shares with it.  A wildcard isn't a host, it covers those under its
+// parent, so the domain they're in is linked to it.  Names which aren't
+// hostnames, such as organisation CNs, get nothing.
+func DescribeCertName(name string) []Summarisable {
+
+	if strings.HasPrefix(name, "*.") {
+		par := ExtractDomain(name[2:])
+		if par == "" || !hostnameRegex.MatchString(name[2:]) {
+			return nil
+		}
+		return []Summarisable{
+			&Node{par, "domain"},
+			&Edge{par, name, "covers"},
+		}
+	}
+
+	if !hostnameRegex.MatchString(name) || ipAddrRegex.MatchString(name) {
+		return nil
+	}
+
+	return DescribeHostname(name)
+
+}